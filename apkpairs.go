@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-steplib/bitrise-step-flank/axml"
+)
+
+// the API level of flank's default android device (NexusLowRes)
+const defaultDeviceAPILevel = 28
+
+// apkPair is an app APK and the test APK running against it
+type apkPair struct {
+	App  string
+	Test string
+}
+
+// returns gcloud.app and gcloud.test, then the additional-app-test-apks pairs, an additional pair without app tests gcloud.app,
+// command flags override the gcloud values
+func apkPairs(cfg flankConfig, commandFlags []string) []apkPair {
+	app, test := cfg.Gcloud.App, cfg.Gcloud.Test
+	if value := flagValue(commandFlags, "--app"); value != "" {
+		app = value
+	}
+	if value := flagValue(commandFlags, "--test"); value != "" {
+		test = value
+	}
+
+	pairs := []apkPair{{App: app, Test: test}}
+	for _, apks := range cfg.Flank.AdditionalAppTestApks {
+		pair := apkPair{App: apks.App, Test: apks.Test}
+		if pair.App == "" {
+			pair.App = app
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+func parseAPILevel(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case string:
+		level, err := strconv.Atoi(v)
+		return level, err == nil
+	}
+	return 0, false
+}
+
+// returns the distinct API levels of the configured devices in ascending order,
+// --device flags override the config devices, devices with a non numeric version are skipped
+func deviceAPILevels(cfg flankConfig, commandFlags []string) []int {
+	var versions []interface{}
+	if devices := flagValues(commandFlags, "--device"); len(devices) > 0 {
+		for _, device := range devices {
+			for _, field := range strings.Split(device, ",") {
+				if strings.HasPrefix(field, "version=") {
+					versions = append(versions, strings.TrimPrefix(field, "version="))
+				}
+			}
+		}
+	} else {
+		for _, device := range cfg.Gcloud.Device {
+			versions = append(versions, device["version"])
+		}
+	}
+	if len(versions) == 0 {
+		return []int{defaultDeviceAPILevel}
+	}
+
+	var levels []int
+	seen := map[int]bool{}
+	for _, version := range versions {
+		if level, ok := parseAPILevel(version); ok && !seen[level] {
+			seen[level] = true
+			levels = append(levels, level)
+		}
+	}
+	sort.Ints(levels)
+	return levels
+}
+
+// returns the problems of running the test APK against the app on devices of the API levels
+func checkAPKPair(pair apkPair, app, test axml.Manifest, apiLevels []int) []string {
+	var problems []string
+	if len(test.TargetPackages) == 0 {
+		problems = append(problems, fmt.Sprintf("%s: no instrumentation found in the test APK", pair.Test))
+	}
+	for _, targetPackage := range test.TargetPackages {
+		if targetPackage != app.Package {
+			problems = append(problems, fmt.Sprintf("%s: instrumentation targets %s, but the package of %s is %s", pair.Test, targetPackage, pair.App, app.Package))
+		}
+	}
+
+	for _, apk := range []struct {
+		pth      string
+		manifest axml.Manifest
+	}{
+		{pair.App, app},
+		{pair.Test, test},
+	} {
+		if apk.manifest.MinSDKCodename != "" {
+			problems = append(problems, fmt.Sprintf("%s: min SDK is the %s preview, which does not run on released API levels", apk.pth, apk.manifest.MinSDKCodename))
+			continue
+		}
+		for _, level := range apiLevels {
+			if level < apk.manifest.MinSDK {
+				problems = append(problems, fmt.Sprintf("%s: min SDK %d is above the API level %d of a configured device", apk.pth, apk.manifest.MinSDK, level))
+			}
+		}
+	}
+	return problems
+}
+
+// reads the manifests of the pair and returns its problems, pairs with a missing or GCS hosted APK are skipped
+func inspectAPKPair(pair apkPair, apiLevels []int) (problems []string, skipped bool, err error) {
+	if pair.App == "" || pair.Test == "" || strings.HasPrefix(pair.App, gcsPathPrefix) || strings.HasPrefix(pair.Test, gcsPathPrefix) {
+		return nil, true, nil
+	}
+
+	app, err := axml.ReadAPKManifest(os.ExpandEnv(pair.App))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read the manifest of %s, error: %s", pair.App, err)
+	}
+	test, err := axml.ReadAPKManifest(os.ExpandEnv(pair.Test))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read the manifest of %s, error: %s", pair.Test, err)
+	}
+	return checkAPKPair(pair, app, test, apiLevels), false, nil
+}
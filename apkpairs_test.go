@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-steplib/bitrise-step-flank/axml"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_apkPairs(t *testing.T) {
+	var cfg flankConfig
+	if err := yaml.Unmarshal([]byte(`gcloud:
+  app: ./app.apk
+  test: ./test.apk
+  device:
+  - model: Pixel2
+    version: 29
+  - model: NexusLowRes
+    version: "23"
+  - model: Pixel2
+    version: 29
+flank:
+  additional-app-test-apks:
+  - app: ./other.apk
+    test: ./other-test.apk
+  - test: ./lib-test.apk
+`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []apkPair{
+		{App: "./flavor.apk", Test: "./test.apk"},
+		{App: "./other.apk", Test: "./other-test.apk"},
+		{App: "./flavor.apk", Test: "./lib-test.apk"},
+	}
+	if got := apkPairs(cfg, []string{"--app=./flavor.apk"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("apkPairs() = %v, want %v", got, want)
+	}
+
+	if got, want := deviceAPILevels(cfg, nil), []int{23, 29}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deviceAPILevels() = %v, want %v", got, want)
+	}
+	if got, want := deviceAPILevels(cfg, []string{"--device", "model=Pixel2,version=30"}), []int{30}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deviceAPILevels() with --device = %v, want %v", got, want)
+	}
+	if got, want := deviceAPILevels(flankConfig{}, nil), []int{defaultDeviceAPILevel}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deviceAPILevels() without devices = %v, want %v", got, want)
+	}
+}
+
+func Test_checkAPKPair(t *testing.T) {
+	pair := apkPair{App: "app.apk", Test: "test.apk"}
+	app := axml.Manifest{Package: "com.example", MinSDK: 21}
+
+	tests := []struct {
+		name      string
+		app       axml.Manifest
+		test      axml.Manifest
+		apiLevels []int
+		want      []string
+	}{
+		{
+			name:      "matching pair",
+			app:       app,
+			test:      axml.Manifest{Package: "com.example.test", MinSDK: 21, TargetPackages: []string{"com.example"}},
+			apiLevels: []int{21, 29},
+		},
+		{
+			name:      "other flavor",
+			app:       app,
+			test:      axml.Manifest{Package: "com.example.free.test", MinSDK: 21, TargetPackages: []string{"com.example.free"}},
+			apiLevels: []int{29},
+			want:      []string{"test.apk: instrumentation targets com.example.free, but the package of app.apk is com.example"},
+		},
+		{
+			name:      "min SDK above device",
+			app:       axml.Manifest{Package: "com.example", MinSDK: 26},
+			test:      axml.Manifest{Package: "com.example.test", MinSDKCodename: "R", TargetPackages: []string{"com.example"}},
+			apiLevels: []int{23, 29},
+			want: []string{
+				"app.apk: min SDK 26 is above the API level 23 of a configured device",
+				"test.apk: min SDK is the R preview, which does not run on released API levels",
+			},
+		},
+		{
+			name:      "no instrumentation",
+			app:       app,
+			test:      axml.Manifest{Package: "com.example", MinSDK: 21},
+			apiLevels: []int{29},
+			want:      []string{"test.apk: no instrumentation found in the test APK"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkAPKPair(pair, tt.app, tt.test, tt.apiLevels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkAPKPair() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
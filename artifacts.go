@@ -0,0 +1,184 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const artifactsZipFileName = "flank-results.zip"
+
+// artifactFilter selects the files to export by their slash separated path relative to the result dir.
+// A pattern without a slash is matched against the file name at any depth, * and ? do not match a slash
+// and ** matches any number of directories. An empty include list means every file.
+type artifactFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// converts a glob pattern of a slash separated relative path to a regexp: * and ? match inside a path segment,
+// ** matches any number of segments, a pattern without a slash matches the file name at any depth
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	if !strings.Contains(pattern, "/") {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func matchAnyGlob(patterns []string, relPath string) (bool, error) {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := globToRegexp(pattern)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern (%s), error: %s", pattern, err)
+		}
+		if re.MatchString(relPath) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f artifactFilter) match(relPath string) (bool, error) {
+	relPath = filepath.ToSlash(relPath)
+
+	if excluded, err := matchAnyGlob(f.Exclude, relPath); err != nil || excluded {
+		return false, err
+	}
+
+	var hasInclude bool
+	for _, pattern := range f.Include {
+		if strings.TrimSpace(pattern) != "" {
+			hasInclude = true
+			break
+		}
+	}
+	if !hasInclude {
+		return true, nil
+	}
+	return matchAnyGlob(f.Include, relPath)
+}
+
+// walks the result dir and calls fn with every file selected by the filter
+func walkArtifacts(resultDir string, filter artifactFilter, fn func(pth, relPath string) error) error {
+	return filepath.Walk(resultDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(resultDir, pth)
+		if err != nil {
+			return err
+		}
+		if ok, err := filter.match(relPath); err != nil || !ok {
+			return err
+		}
+		return fn(pth, relPath)
+	})
+}
+
+// copies the files of the result dir selected by the filter to the dir under BITRISE_DEPLOY_DIR,
+// the directory structure of the result dir is kept
+func exportArtifacts(resultDir, destDir string, filter artifactFilter, copiedHandler func(src, dest string)) error {
+	return walkArtifacts(resultDir, filter, func(srcFile, relPath string) error {
+		destinationFile := filepath.Join(destDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(destinationFile), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(srcFile, destinationFile); err != nil {
+			return err
+		}
+
+		if copiedHandler != nil {
+			copiedHandler(srcFile, destinationFile)
+		}
+		return nil
+	})
+}
+
+// compresses the files of the result dir selected by the filter into a single zip file
+func zipArtifacts(resultDir, zipPath string, filter artifactFilter) (err error) {
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := zipFile.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	w := zip.NewWriter(zipFile)
+	if err := walkArtifacts(resultDir, filter, func(pth, relPath string) error {
+		return addFileToZip(w, pth, filepath.ToSlash(relPath))
+	}); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func addFileToZip(w *zip.Writer, pth, name string) error {
+	info, err := os.Stat(pth)
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	entry, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file, error: %s", err)
+		}
+	}()
+
+	_, err = io.Copy(entry, f)
+	return err
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// lists the files under dir recursively, by their slash separated relative path
+func listFilesRecursive(t *testing.T, dir string) []string {
+	var files []string
+	if err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, pth)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func Test_globToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		pth     string
+		match   bool
+	}{
+		{pattern: "docs/**", pth: "docs/guide/setup.md", match: true},
+		{pattern: "**/*.md", pth: "README.md", match: true},
+		{pattern: "**/*.md", pth: "app/docs/README.md", match: true},
+		{pattern: "*.md", pth: "app/README.md", match: true},
+		{pattern: "docs/*.md", pth: "app/docs/README.md", match: false},
+		{pattern: "app/src/main/java/com/example/login/**", pth: "app/src/main/java/com/example/login/LoginActivity.kt", match: true},
+		{pattern: "gradle/wrapper/gradle-wrapper.propertie?", pth: "gradle/wrapper/gradle-wrapper.properties", match: true},
+		{pattern: "gradle/*/gradle-wrapper.properties", pth: "gradle/a/b/gradle-wrapper.properties", match: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.pth, func(t *testing.T) {
+			re, err := globToRegexp(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := re.MatchString(tt.pth); got != tt.match {
+				t.Errorf("globToRegexp(%s).MatchString(%s) = %v, want %v", tt.pattern, tt.pth, got, tt.match)
+			}
+		})
+	}
+}
+
+func Test_artifactFilter_match(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  artifactFilter
+		relPath string
+		want    bool
+	}{
+		{name: "no patterns", filter: artifactFilter{}, relPath: "shard_0/device/logcat", want: true},
+		{name: "file name pattern at any depth", filter: artifactFilter{Include: []string{"*.xml"}}, relPath: "shard_0/device/test_result_1.xml", want: true},
+		{name: "file name pattern no match", filter: artifactFilter{Include: []string{"*.xml"}}, relPath: "shard_0/device/logcat", want: false},
+		{name: "path pattern star does not cross dirs", filter: artifactFilter{Include: []string{"shard_*/*.xml"}}, relPath: "shard_0/device/test_result_1.xml", want: false},
+		{name: "double star", filter: artifactFilter{Include: []string{"shard_*/**/*.xml"}}, relPath: "shard_0/device/test_result_1.xml", want: true},
+		{name: "double star matches no dir", filter: artifactFilter{Include: []string{"**/JUnitReport.xml"}}, relPath: "JUnitReport.xml", want: true},
+		{name: "exclude wins", filter: artifactFilter{Include: []string{"**"}, Exclude: []string{"*.mp4"}}, relPath: "shard_0/device/video.mp4", want: false},
+		{name: "blank include pattern", filter: artifactFilter{Include: []string{" "}}, relPath: "logcat", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.match(tt.relPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("match(%s) = %v, want %v", tt.relPath, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_exportArtifacts(t *testing.T) {
+	srcDir, err := pathutil.NormalizedOSTempDirPath("test-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createDummyFiles(srcDir, []string{
+		"result-dir-1/res1-file1",
+		"result-dir-1/res1-dir/res1-dir-file1",
+		"result-dir-2/res2-file1.xml",
+		"result-dir-2/res2-file2",
+		"result-dir-2/res2-dir/res2-dir-file1.xml",
+		"result-dir-2/res2-dir/res2-dir-file2.mp4",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		filter    artifactFilter
+		wantFiles []string
+	}{
+		{
+			name:      "everything",
+			wantFiles: []string{"res2-dir/res2-dir-file1.xml", "res2-dir/res2-dir-file2.mp4", "res2-file1.xml", "res2-file2"},
+		},
+		{
+			name:      "include and exclude",
+			filter:    artifactFilter{Include: []string{"*.xml", "*.mp4"}, Exclude: []string{"res2-dir/*.mp4"}},
+			wantFiles: []string{"res2-dir/res2-dir-file1.xml", "res2-file1.xml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destDir, err := pathutil.NormalizedOSTempDirPath("test-dest")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := exportArtifacts(filepath.Join(srcDir, "result-dir-2"), destDir, tt.filter, nil); err != nil {
+				t.Fatalf("exportArtifacts() error = %v", err)
+			}
+
+			if got := listFilesRecursive(t, destDir); !reflect.DeepEqual(got, tt.wantFiles) {
+				t.Errorf("exported files = %v, want %v", got, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func Test_zipArtifacts(t *testing.T) {
+	resultDir, err := pathutil.NormalizedOSTempDirPath("test-zip-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := createDummyFiles(resultDir, []string{
+		"JUnitReport.xml",
+		"shard_0/device/test_result_1.xml",
+		"shard_0/device/video.mp4",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	destDir, err := pathutil.NormalizedOSTempDirPath("test-zip-dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zipPath := filepath.Join(destDir, artifactsZipFileName)
+	if err := zipArtifacts(resultDir, zipPath, artifactFilter{Exclude: []string{"*.mp4"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+
+	if want := []string{"JUnitReport.xml", "shard_0/device/test_result_1.xml"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("zipped files = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,339 @@
+// Package axml decodes Android binary XML files, like the AndroidManifest.xml of APKs,
+// into an element tree without the Android SDK.
+package axml
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf16"
+)
+
+const (
+	chunkXML           = 0x0003
+	chunkStringPool    = 0x0001
+	chunkResourceMap   = 0x0180
+	chunkStartElement  = 0x0102
+	chunkEndElement    = 0x0103
+	chunkHeaderSize    = 8
+	nodeHeaderSize     = 16
+	stringPoolUTF8     = 1 << 8
+	noEntry            = 0xffffffff
+	typeReference      = 0x01
+	typeString         = 0x03
+	typeFloat          = 0x04
+	typeIntDec         = 0x10
+	typeIntHex         = 0x11
+	typeIntBoolean     = 0x12
+	attributeValueSize = 20
+	maxDepth           = 256
+)
+
+// Element is an XML element
+type Element struct {
+	Name       string
+	Attributes []Attribute
+	Children   []*Element
+}
+
+// Attribute is an attribute of an element, the value of a typed attribute is formatted as a string
+type Attribute struct {
+	Namespace string
+	Name      string
+	// ResourceID is the android attribute resource ID of the name, 0 if the attribute has none
+	ResourceID uint32
+	Value      string
+}
+
+// Attr returns the value of the attribute with the name or the resource ID,
+// the resource ID is checked too as the attribute names may be stripped from release builds
+func (e *Element) Attr(name string, resourceID uint32) (string, bool) {
+	for _, a := range e.Attributes {
+		if a.Name == name || (resourceID != 0 && a.ResourceID == resourceID) {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Find returns the descendant elements with the name
+func (e *Element) Find(name string) []*Element {
+	var found []*Element
+	for _, child := range e.Children {
+		if child.Name == name {
+			found = append(found, child)
+		}
+		found = append(found, child.Find(name)...)
+	}
+	return found
+}
+
+type decoder struct {
+	data        []byte
+	strings     []string
+	resourceIDs []uint32
+}
+
+func (d *decoder) u16(off int) (int, error) {
+	if off < 0 || off+2 > len(d.data) {
+		return 0, fmt.Errorf("offset %#x out of range", off)
+	}
+	return int(binary.LittleEndian.Uint16(d.data[off:])), nil
+}
+
+func (d *decoder) u32(off int) (uint32, error) {
+	if off < 0 || off+4 > len(d.data) {
+		return 0, fmt.Errorf("offset %#x out of range", off)
+	}
+	return binary.LittleEndian.Uint32(d.data[off:]), nil
+}
+
+func (d *decoder) str(idx uint32) string {
+	if idx == noEntry || int(idx) >= len(d.strings) {
+		return ""
+	}
+	return d.strings[idx]
+}
+
+// Parse decodes a binary XML file and returns its root element
+func Parse(data []byte) (*Element, error) {
+	d := &decoder{data: data}
+	typ, err := d.u16(0)
+	if err != nil {
+		return nil, err
+	}
+	if typ != chunkXML {
+		return nil, fmt.Errorf("not a binary xml file")
+	}
+	headerSize, err := d.u16(2)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &Element{}
+	stack := []*Element{root}
+	for off := headerSize; off < len(data); {
+		chunkType, err := d.u16(off)
+		if err != nil {
+			return nil, err
+		}
+		size, err := d.u32(off + 4)
+		if err != nil {
+			return nil, err
+		}
+		if size < chunkHeaderSize || uint64(off)+uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid chunk size at %#x", off)
+		}
+
+		switch chunkType {
+		case chunkStringPool:
+			if err := d.readStringPool(off); err != nil {
+				return nil, err
+			}
+		case chunkResourceMap:
+			for p := off + chunkHeaderSize; p+4 <= off+int(size); p += 4 {
+				id, err := d.u32(p)
+				if err != nil {
+					return nil, err
+				}
+				d.resourceIDs = append(d.resourceIDs, id)
+			}
+		case chunkStartElement:
+			if len(stack) > maxDepth {
+				return nil, fmt.Errorf("xml is nested too deep")
+			}
+			element, err := d.readStartElement(off)
+			if err != nil {
+				return nil, err
+			}
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, element)
+			stack = append(stack, element)
+		case chunkEndElement:
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("unexpected end element at %#x", off)
+			}
+			stack = stack[:len(stack)-1]
+		}
+		off += int(size)
+	}
+
+	if len(root.Children) != 1 {
+		return nil, fmt.Errorf("expected a single root element, found %d", len(root.Children))
+	}
+	return root.Children[0], nil
+}
+
+func (d *decoder) readStringPool(off int) error {
+	headerSize, err := d.u16(off + 2)
+	if err != nil {
+		return err
+	}
+	count, err := d.u32(off + 8)
+	if err != nil {
+		return err
+	}
+	flags, err := d.u32(off + 16)
+	if err != nil {
+		return err
+	}
+	stringsStart, err := d.u32(off + 20)
+	if err != nil {
+		return err
+	}
+	if uint64(count)*4 > uint64(len(d.data)) {
+		return fmt.Errorf("invalid string count: %d", count)
+	}
+
+	d.strings = make([]string, count)
+	for i := range d.strings {
+		strOff, err := d.u32(off + headerSize + i*4)
+		if err != nil {
+			return err
+		}
+		p := off + int(stringsStart) + int(strOff)
+		if flags&stringPoolUTF8 != 0 {
+			d.strings[i], err = d.utf8String(p)
+		} else {
+			d.strings[i], err = d.utf16String(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// utf8 strings are prefixed with the utf16 and the utf8 length, both are 1 or 2 bytes long
+func (d *decoder) utf8String(off int) (string, error) {
+	length := func(p int) (int, int, error) {
+		if p >= len(d.data) {
+			return 0, 0, fmt.Errorf("offset %#x out of range", p)
+		}
+		n := int(d.data[p])
+		if n&0x80 == 0 {
+			return n, p + 1, nil
+		}
+		if p+1 >= len(d.data) {
+			return 0, 0, fmt.Errorf("offset %#x out of range", p+1)
+		}
+		return (n&0x7f)<<8 | int(d.data[p+1]), p + 2, nil
+	}
+	_, p, err := length(off)
+	if err != nil {
+		return "", err
+	}
+	n, p, err := length(p)
+	if err != nil {
+		return "", err
+	}
+	if p+n > len(d.data) {
+		return "", fmt.Errorf("string at %#x out of range", off)
+	}
+	return string(d.data[p : p+n]), nil
+}
+
+// utf16 strings are prefixed with the length in 1 or 2 units
+func (d *decoder) utf16String(off int) (string, error) {
+	n, err := d.u16(off)
+	if err != nil {
+		return "", err
+	}
+	p := off + 2
+	if n&0x8000 != 0 {
+		low, err := d.u16(p)
+		if err != nil {
+			return "", err
+		}
+		n = (n&0x7fff)<<16 | low
+		p += 2
+	}
+	if p+n*2 > len(d.data) {
+		return "", fmt.Errorf("string at %#x out of range", off)
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(d.data[p+i*2:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+func (d *decoder) readStartElement(off int) (*Element, error) {
+	ext := off + nodeHeaderSize
+	name, err := d.u32(ext + 4)
+	if err != nil {
+		return nil, err
+	}
+	attributeStart, err := d.u16(ext + 8)
+	if err != nil {
+		return nil, err
+	}
+	attributeSize, err := d.u16(ext + 10)
+	if err != nil {
+		return nil, err
+	}
+	attributeCount, err := d.u16(ext + 12)
+	if err != nil {
+		return nil, err
+	}
+	if attributeSize < attributeValueSize {
+		return nil, fmt.Errorf("invalid attribute size at %#x", off)
+	}
+
+	element := &Element{Name: d.str(name)}
+	for i := 0; i < attributeCount; i++ {
+		p := ext + attributeStart + i*attributeSize
+		ns, err := d.u32(p)
+		if err != nil {
+			return nil, err
+		}
+		attrName, err := d.u32(p + 4)
+		if err != nil {
+			return nil, err
+		}
+		rawValue, err := d.u32(p + 8)
+		if err != nil {
+			return nil, err
+		}
+		dataType, err := d.u16(p + 14)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.u32(p + 16)
+		if err != nil {
+			return nil, err
+		}
+
+		attr := Attribute{Namespace: d.str(ns), Name: d.str(attrName)}
+		if attrName != noEntry && int(attrName) < len(d.resourceIDs) {
+			attr.ResourceID = d.resourceIDs[attrName]
+		}
+		if rawValue != noEntry {
+			attr.Value = d.str(rawValue)
+		} else {
+			attr.Value = d.formatValue(dataType>>8, value)
+		}
+		element.Attributes = append(element.Attributes, attr)
+	}
+	return element, nil
+}
+
+func (d *decoder) formatValue(dataType int, value uint32) string {
+	switch dataType {
+	case typeString:
+		return d.str(value)
+	case typeIntDec:
+		return strconv.FormatInt(int64(int32(value)), 10)
+	case typeIntHex:
+		return fmt.Sprintf("0x%08x", value)
+	case typeFloat:
+		return strconv.FormatFloat(float64(math.Float32frombits(value)), 'g', -1, 32)
+	case typeIntBoolean:
+		return strconv.FormatBool(value != 0)
+	case typeReference:
+		return fmt.Sprintf("@0x%08x", value)
+	default:
+		return fmt.Sprintf("0x%08x", value)
+	}
+}
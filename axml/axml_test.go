@@ -0,0 +1,288 @@
+package axml
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+type attrSpec struct {
+	name       string
+	resourceID uint32
+	// string value if dataType is 0
+	value    string
+	dataType byte
+	data     uint32
+}
+
+type elementSpec struct {
+	name     string
+	attrs    []attrSpec
+	children []elementSpec
+}
+
+const androidNS = "http://schemas.android.com/apk/res/android"
+
+type builder struct {
+	strs     []string
+	strIdx   map[string]uint32
+	resIDs   []uint32
+	elements []byte
+	utf8     bool
+}
+
+func (b *builder) str(s string) uint32 {
+	if idx, ok := b.strIdx[s]; ok {
+		return idx
+	}
+	b.strIdx[s] = uint32(len(b.strs))
+	b.strs = append(b.strs, s)
+	return b.strIdx[s]
+}
+
+func le16(v int) []byte {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], uint16(v))
+	return b[:]
+}
+
+func le32(v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return b[:]
+}
+
+func chunk(typ, headerSize int, body []byte) []byte {
+	out := append(le16(typ), le16(headerSize)...)
+	out = append(out, le32(uint32(8+len(body)))...)
+	return append(out, body...)
+}
+
+func (b *builder) element(e elementSpec) {
+	var attrs []byte
+	for _, a := range e.attrs {
+		attrs = append(attrs, le32(b.str(androidNS))...)
+		attrs = append(attrs, le32(b.str(a.name))...)
+		if a.dataType == 0 {
+			attrs = append(attrs, le32(b.str(a.value))...)
+			attrs = append(attrs, le16(8)...)
+			attrs = append(attrs, 0, typeString)
+			attrs = append(attrs, le32(b.str(a.value))...)
+		} else {
+			attrs = append(attrs, le32(noEntry)...)
+			attrs = append(attrs, le16(8)...)
+			attrs = append(attrs, 0, a.dataType)
+			attrs = append(attrs, le32(a.data)...)
+		}
+	}
+
+	// line number, comment, ns, name, attribute start, size and count, id, class and style index
+	body := append(le32(1), le32(noEntry)...)
+	body = append(body, le32(noEntry)...)
+	body = append(body, le32(b.str(e.name))...)
+	body = append(body, le16(20)...)
+	body = append(body, le16(attributeValueSize)...)
+	body = append(body, le16(len(e.attrs))...)
+	body = append(body, le16(0)...)
+	body = append(body, le16(0)...)
+	body = append(body, le16(0)...)
+	b.elements = append(b.elements, chunk(chunkStartElement, nodeHeaderSize, append(body, attrs...))...)
+
+	for _, child := range e.children {
+		b.element(child)
+	}
+
+	body = append(le32(1), le32(noEntry)...)
+	body = append(body, le32(noEntry)...)
+	body = append(body, le32(b.str(e.name))...)
+	b.elements = append(b.elements, chunk(chunkEndElement, nodeHeaderSize, body)...)
+}
+
+// builds a binary xml file, the attribute names with resource IDs are added to the string pool first as aapt does
+func buildXML(root elementSpec, utf8 bool) []byte {
+	b := &builder{strIdx: map[string]uint32{}, utf8: utf8}
+	var collect func(e elementSpec)
+	collect = func(e elementSpec) {
+		for _, a := range e.attrs {
+			if a.resourceID != 0 {
+				if _, ok := b.strIdx[a.name]; !ok {
+					b.str(a.name)
+					b.resIDs = append(b.resIDs, a.resourceID)
+				}
+			}
+		}
+		for _, child := range e.children {
+			collect(child)
+		}
+	}
+	collect(root)
+	b.element(root)
+
+	var offsets, data []byte
+	for _, s := range b.strs {
+		offsets = append(offsets, le32(uint32(len(data)))...)
+		if utf8 {
+			data = append(data, byte(len(s)), byte(len(s)))
+			data = append(data, s...)
+			data = append(data, 0)
+			continue
+		}
+		units := utf16.Encode([]rune(s))
+		data = append(data, le16(len(units))...)
+		for _, u := range units {
+			data = append(data, le16(int(u))...)
+		}
+		data = append(data, 0, 0)
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	var flags uint32
+	if utf8 {
+		flags = stringPoolUTF8
+	}
+	poolHeader := append(le32(uint32(len(b.strs))), le32(0)...)
+	poolHeader = append(poolHeader, le32(flags)...)
+	poolHeader = append(poolHeader, le32(uint32(28+len(offsets)))...)
+	poolHeader = append(poolHeader, le32(0)...)
+	pool := chunk(chunkStringPool, 28, append(append(poolHeader, offsets...), data...))
+
+	var resMap []byte
+	for _, id := range b.resIDs {
+		resMap = append(resMap, le32(id)...)
+	}
+
+	body := append(pool, chunk(chunkResourceMap, 8, resMap)...)
+	body = append(body, b.elements...)
+	return chunk(chunkXML, 8, body)
+}
+
+func testManifest(pkg string, minSDK attrSpec, targetPackage string) elementSpec {
+	manifest := elementSpec{
+		name:  "manifest",
+		attrs: []attrSpec{{name: "package", value: pkg}},
+		children: []elementSpec{
+			{name: "uses-sdk", attrs: []attrSpec{minSDK, {name: "targetSdkVersion", resourceID: 0x01010270, dataType: typeIntDec, data: 29}}},
+			{name: "application", attrs: []attrSpec{{name: "debuggable", resourceID: 0x0101000f, dataType: typeIntBoolean, data: 0xffffffff}}},
+		},
+	}
+	if targetPackage != "" {
+		manifest.children = append(manifest.children, elementSpec{
+			name:  "instrumentation",
+			attrs: []attrSpec{{name: "targetPackage", resourceID: targetPackageResourceID, value: targetPackage}},
+		})
+	}
+	return manifest
+}
+
+func TestParse(t *testing.T) {
+	data := buildXML(testManifest("com.example", attrSpec{name: "minSdkVersion", resourceID: minSdkVersionResourceID, dataType: typeIntDec, data: 21}, ""), false)
+	root, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Element{
+		Name:       "manifest",
+		Attributes: []Attribute{{Namespace: androidNS, Name: "package", Value: "com.example"}},
+		Children: []*Element{
+			{Name: "uses-sdk", Attributes: []Attribute{
+				{Namespace: androidNS, Name: "minSdkVersion", ResourceID: minSdkVersionResourceID, Value: "21"},
+				{Namespace: androidNS, Name: "targetSdkVersion", ResourceID: 0x01010270, Value: "29"},
+			}},
+			{Name: "application", Attributes: []Attribute{
+				{Namespace: androidNS, Name: "debuggable", ResourceID: 0x0101000f, Value: "true"},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(root, want) {
+		t.Errorf("Parse() = %+v, want %+v", root, want)
+	}
+
+	if _, err := Parse([]byte("<manifest/>")); err == nil {
+		t.Error("Parse() of a text xml error = nil")
+	}
+	if _, err := Parse(data[:len(data)-10]); err == nil {
+		t.Error("Parse() of a truncated xml error = nil")
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want Manifest
+	}{
+		{
+			name: "test APK with utf16 strings",
+			data: buildXML(testManifest("com.example.test", attrSpec{name: "minSdkVersion", resourceID: minSdkVersionResourceID, dataType: typeIntDec, data: 23}, "com.example"), false),
+			want: Manifest{Package: "com.example.test", MinSDK: 23, TargetPackages: []string{"com.example"}},
+		},
+		{
+			name: "app with utf8 strings and stripped attribute names",
+			data: buildXML(testManifest("com.example", attrSpec{name: "", resourceID: minSdkVersionResourceID, dataType: typeIntDec, data: 21}, ""), true),
+			want: Manifest{Package: "com.example", MinSDK: 21},
+		},
+		{
+			name: "preview SDK",
+			data: buildXML(testManifest("com.example", attrSpec{name: "minSdkVersion", resourceID: minSdkVersionResourceID, value: "R"}, ""), true),
+			want: Manifest{Package: "com.example", MinSDKCodename: "R"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseManifest(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseManifest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAPKManifest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-axml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Log(err)
+		}
+	}()
+
+	apkPath := filepath.Join(tmpDir, "app.apk")
+	f, err := os.Create(apkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	fw, err := w.Create(manifestFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(buildXML(testManifest("com.example", attrSpec{name: "minSdkVersion", resourceID: minSdkVersionResourceID, dataType: typeIntDec, data: 21}, ""), false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadAPKManifest(apkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Manifest{Package: "com.example", MinSDK: 21}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAPKManifest() = %+v, want %+v", got, want)
+	}
+}
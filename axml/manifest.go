@@ -0,0 +1,90 @@
+package axml
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+const (
+	manifestFileName = "AndroidManifest.xml"
+	// resource IDs of the android attributes
+	minSdkVersionResourceID = 0x0101020c
+	targetPackageResourceID = 0x01010021
+	// the platform default if uses-sdk is missing
+	defaultMinSDK = 1
+)
+
+// Manifest is the part of an AndroidManifest.xml needed to pair app and test APKs
+type Manifest struct {
+	Package string
+	MinSDK  int
+	// MinSDKCodename is set instead of MinSDK for preview SDKs, like "R"
+	MinSDKCodename string
+	// TargetPackages are the targetPackage values of the instrumentation elements
+	TargetPackages []string
+}
+
+// ParseManifest decodes a binary AndroidManifest.xml
+func ParseManifest(data []byte) (Manifest, error) {
+	root, err := Parse(data)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if root.Name != "manifest" {
+		return Manifest{}, fmt.Errorf("unexpected root element: %s", root.Name)
+	}
+
+	manifest := Manifest{MinSDK: defaultMinSDK}
+	manifest.Package, _ = root.Attr("package", 0)
+	for _, usesSDK := range root.Find("uses-sdk") {
+		value, ok := usesSDK.Attr("minSdkVersion", minSdkVersionResourceID)
+		if !ok {
+			continue
+		}
+		if minSDK, err := strconv.Atoi(value); err == nil {
+			manifest.MinSDK = minSDK
+		} else {
+			manifest.MinSDK = 0
+			manifest.MinSDKCodename = value
+		}
+	}
+	for _, instrumentation := range root.Find("instrumentation") {
+		if targetPackage, ok := instrumentation.Attr("targetPackage", targetPackageResourceID); ok {
+			manifest.TargetPackages = append(manifest.TargetPackages, targetPackage)
+		}
+	}
+	return manifest, nil
+}
+
+// ReadAPKManifest returns the manifest of the APK
+func ReadAPKManifest(pth string) (Manifest, error) {
+	r, err := zip.OpenReader(pth)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	for _, f := range r.File {
+		if f.Name != manifestFileName {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return Manifest{}, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		if closeErr := rc.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return Manifest{}, fmt.Errorf("failed to read %s, error: %s", f.Name, err)
+		}
+		return ParseManifest(data)
+	}
+	return Manifest{}, fmt.Errorf("no %s found in %s", manifestFileName, pth)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+const (
+	// a test is significantly slower if it took at least 50% and 5 seconds longer than in the baseline
+	slowerTestRatio      = 1.5
+	slowerTestMinSeconds = 5
+)
+
+// baselineDiff compares the test results with the results of a reference build
+type baselineDiff struct {
+	NewFailures  []string     `json:"new_failures"`
+	Fixed        []string     `json:"fixed"`
+	StillFailing []string     `json:"still_failing"`
+	Added        []string     `json:"added"`
+	Removed      []string     `json:"removed"`
+	Slower       []slowerTest `json:"slower"`
+	// Partial is set if the baseline has only the failed tests (a step report without test cases),
+	// then the added, removed and slower tests are not known
+	Partial bool `json:"partial,omitempty"`
+}
+
+type slowerTest struct {
+	Name            string  `json:"name"`
+	BaselineSeconds float64 `json:"baseline_seconds"`
+	Seconds         float64 `json:"seconds"`
+}
+
+func (t slowerTest) String() string {
+	return fmt.Sprintf("%s (%s -> %s)", t.Name, formatSeconds(t.BaselineSeconds), formatSeconds(t.Seconds))
+}
+
+// reads the baseline results: a JUnit xml or a flank-step-report.json of a previous run,
+// partial is set if the step report has only the failed tests
+func readBaselineResults(pth string) (results map[string]testRunResult, partial bool, err error) {
+	if !strings.EqualFold(filepath.Ext(pth), ".json") {
+		suites, err := parseJUnitReport(pth)
+		if err != nil {
+			return nil, false, err
+		}
+		return collectTestRunResults(suites), false, nil
+	}
+
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, false, err
+	}
+	var report stepReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, false, fmt.Errorf("failed to parse %s, error: %s", pth, err)
+	}
+	if report.Tests == nil {
+		return nil, false, fmt.Errorf("%s has no test results", pth)
+	}
+
+	results = map[string]testRunResult{}
+	if report.Tests.Cases == nil {
+		// step reports written before the test cases were added
+		for _, name := range report.Tests.FailedTests {
+			results[name] = testRunResult{Status: testStatusFailed}
+		}
+		return results, true, nil
+	}
+	for _, tc := range report.Tests.Cases {
+		results[tc.Name] = testRunResult{Status: tc.Status, Seconds: tc.TimeSeconds}
+	}
+	return results, false, nil
+}
+
+// compares the results with the baseline
+func diffBaseline(baseline map[string]testRunResult, partial bool, results map[string]testRunResult) baselineDiff {
+	diff := baselineDiff{
+		NewFailures:  []string{},
+		Fixed:        []string{},
+		StillFailing: []string{},
+		Added:        []string{},
+		Removed:      []string{},
+		Slower:       []slowerTest{},
+		Partial:      partial,
+	}
+
+	for name, result := range results {
+		base, inBaseline := baseline[name]
+		failed := result.Status == testStatusFailed
+		baseFailed := inBaseline && base.Status == testStatusFailed
+
+		switch {
+		case failed && baseFailed:
+			diff.StillFailing = append(diff.StillFailing, name)
+		case failed:
+			diff.NewFailures = append(diff.NewFailures, name)
+		case baseFailed:
+			diff.Fixed = append(diff.Fixed, name)
+		}
+		if partial {
+			continue
+		}
+
+		if !inBaseline {
+			diff.Added = append(diff.Added, name)
+		} else if !failed && !baseFailed && base.Seconds > 0 &&
+			result.Seconds >= base.Seconds*slowerTestRatio && result.Seconds-base.Seconds >= slowerTestMinSeconds {
+			diff.Slower = append(diff.Slower, slowerTest{Name: name, BaselineSeconds: base.Seconds, Seconds: result.Seconds})
+		}
+	}
+
+	// a partial baseline has only the failed tests, a missing one can be fixed or removed
+	if !partial {
+		for name := range baseline {
+			if _, ok := results[name]; !ok {
+				diff.Removed = append(diff.Removed, name)
+			}
+		}
+	}
+
+	for _, names := range [][]string{diff.NewFailures, diff.Fixed, diff.StillFailing, diff.Added, diff.Removed} {
+		sort.Strings(names)
+	}
+	sort.Slice(diff.Slower, func(i, j int) bool { return diff.Slower[i].Name < diff.Slower[j].Name })
+	return diff
+}
+
+// returns the classification the exit code policy is applied to if only the new failures should fail the build:
+// a test failure without new failures counts as success
+func releaseBaselineFailures(classification exitClassification, diff baselineDiff) (exitClassification, bool) {
+	if classification.Category != exitCategoryTestFailure || len(diff.NewFailures) > 0 || len(diff.StillFailing) == 0 {
+		return classification, false
+	}
+	return exitClassification{Status: 0, Category: exitCategorySuccess, Description: "only the failures of the baseline failed again"}, true
+}
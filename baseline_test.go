@@ -0,0 +1,104 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_diffBaseline(t *testing.T) {
+	baseline := map[string]testRunResult{
+		"A#stillFailing": {Status: testStatusFailed, Seconds: 1},
+		"A#fixed":        {Status: testStatusFailed, Seconds: 1},
+		"A#newFailure":   {Status: testStatusPassed, Seconds: 1},
+		"A#slower":       {Status: testStatusPassed, Seconds: 10},
+		"A#bitSlower":    {Status: testStatusPassed, Seconds: 10},
+		"A#removed":      {Status: testStatusPassed, Seconds: 1},
+	}
+	results := map[string]testRunResult{
+		"A#stillFailing": {Status: testStatusFailed, Seconds: 1},
+		"A#fixed":        {Status: testStatusFlaky, Seconds: 1},
+		"A#newFailure":   {Status: testStatusFailed, Seconds: 1},
+		"A#slower":       {Status: testStatusPassed, Seconds: 16},
+		"A#bitSlower":    {Status: testStatusPassed, Seconds: 14},
+		"A#added":        {Status: testStatusFailed, Seconds: 1},
+	}
+
+	want := baselineDiff{
+		NewFailures:  []string{"A#added", "A#newFailure"},
+		Fixed:        []string{"A#fixed"},
+		StillFailing: []string{"A#stillFailing"},
+		Added:        []string{"A#added"},
+		Removed:      []string{"A#removed"},
+		Slower:       []slowerTest{{Name: "A#slower", BaselineSeconds: 10, Seconds: 16}},
+	}
+	got := diffBaseline(baseline, false, results)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffBaseline() = %+v, want %+v", got, want)
+	}
+
+	partial := diffBaseline(map[string]testRunResult{"A#stillFailing": {Status: testStatusFailed}, "A#gone": {Status: testStatusFailed}}, true, results)
+	wantPartial := baselineDiff{
+		NewFailures:  []string{"A#added", "A#newFailure"},
+		Fixed:        []string{},
+		StillFailing: []string{"A#stillFailing"},
+		Added:        []string{},
+		Removed:      []string{},
+		Slower:       []slowerTest{},
+		Partial:      true,
+	}
+	if !reflect.DeepEqual(partial, wantPartial) {
+		t.Errorf("diffBaseline() of a partial baseline = %+v, want %+v", partial, wantPartial)
+	}
+
+	testFailure := classifyExitStatus("v20.08.0", 10, nil)
+	if _, released := releaseBaselineFailures(testFailure, got); released {
+		t.Error("releaseBaselineFailures() released a run with new failures")
+	}
+	if status, released := releaseBaselineFailures(testFailure, baselineDiff{StillFailing: []string{"A#stillFailing"}}); !released || status.Category != exitCategorySuccess {
+		t.Errorf("releaseBaselineFailures() = %+v, %v, want success", status, released)
+	}
+}
+
+func Test_readBaselineResults(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-baseline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	junitPath := writeTestFile(t, tmpDir, junitReportFileName, testJUnitReport)
+	suites, err := parseJUnitReport(junitPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromJUnit, partial, err := readBaselineResults(junitPath)
+	if err != nil || partial {
+		t.Fatalf("readBaselineResults() of JUnit partial = %v, error = %v", partial, err)
+	}
+	if want := collectTestRunResults(suites); !reflect.DeepEqual(fromJUnit, want) {
+		t.Errorf("readBaselineResults() of JUnit = %v, want %v", fromJUnit, want)
+	}
+
+	reportPath := filepath.Join(tmpDir, stepReportFileName)
+	if err := writeStepReport(reportPath, stepReport{Tests: newStepReportTests(suites)}); err != nil {
+		t.Fatal(err)
+	}
+	fromReport, partial, err := readBaselineResults(reportPath)
+	if err != nil || partial {
+		t.Fatalf("readBaselineResults() of step report partial = %v, error = %v", partial, err)
+	}
+	if !reflect.DeepEqual(fromReport, fromJUnit) {
+		t.Errorf("readBaselineResults() of step report = %v, want %v", fromReport, fromJUnit)
+	}
+
+	oldReportPath := writeTestFile(t, tmpDir, "old-report.json", `{"tests": {"failed_tests": ["A#a"]}}`)
+	fromOldReport, partial, err := readBaselineResults(oldReportPath)
+	if err != nil || !partial {
+		t.Fatalf("readBaselineResults() of an old step report partial = %v, error = %v", partial, err)
+	}
+	if want := map[string]testRunResult{"A#a": {Status: testStatusFailed}}; !reflect.DeepEqual(fromOldReport, want) {
+		t.Errorf("readBaselineResults() of an old step report = %v, want %v", fromOldReport, want)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	costReportFileName = "CostReport.txt"
+	// Firebase Test Lab prices, the same ones flank uses for its cost estimation
+	virtualDeviceCostPerMinute  = 1.0 / 60
+	physicalDeviceCostPerMinute = 5.0 / 60
+)
+
+// matches the cost lines of flank's cost report, eg.: $0.08 for 1h 5m
+var costLinePattern = regexp.MustCompile(`\$([0-9]+(?:\.[0-9]+)?) for (?:([0-9]+)h\s*)?([0-9]+)m`)
+
+// costReport is the billable time and estimated cost of the run
+type costReport struct {
+	VirtualMinutes  int64   `json:"billable_virtual_minutes"`
+	PhysicalMinutes int64   `json:"billable_physical_minutes"`
+	Cost            float64 `json:"estimated_cost"`
+}
+
+// returns the billable minutes and the estimated cost of the matrices
+func matricesCost(matrices []matrixResult) (virtualMinutes, physicalMinutes int64, cost float64) {
+	for _, m := range matrices {
+		virtualMinutes += m.BillableVirtualMinutes
+		physicalMinutes += m.BillablePhysicalMinutes
+	}
+	cost = float64(virtualMinutes)*virtualDeviceCostPerMinute + float64(physicalMinutes)*physicalDeviceCostPerMinute
+	return
+}
+
+// parses flank's CostReport.txt, the report lists the cost and the billable time of the
+// virtual and physical devices and their total under separate titles
+func parseCostReport(r io.Reader) (costReport, error) {
+	var report costReport
+	var section string
+	var hasTotal bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(ansiEscapePattern.ReplaceAllString(scanner.Text(), ""))
+		switch {
+		case strings.HasPrefix(line, "Virtual devices"):
+			section = "virtual"
+			continue
+		case strings.HasPrefix(line, "Physical devices"):
+			section = "physical"
+			continue
+		case strings.HasPrefix(line, "Total"):
+			section = "total"
+			continue
+		}
+
+		match := costLinePattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		cost, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return costReport{}, err
+		}
+		var minutes int64
+		if match[2] != "" {
+			hours, err := strconv.ParseInt(match[2], 10, 64)
+			if err != nil {
+				return costReport{}, err
+			}
+			minutes = hours * 60
+		}
+		m, err := strconv.ParseInt(match[3], 10, 64)
+		if err != nil {
+			return costReport{}, err
+		}
+		minutes += m
+
+		switch section {
+		case "virtual":
+			report.VirtualMinutes = minutes
+			if !hasTotal {
+				report.Cost += cost
+			}
+		case "physical":
+			report.PhysicalMinutes = minutes
+			if !hasTotal {
+				report.Cost += cost
+			}
+		case "total":
+			report.Cost = cost
+			hasTotal = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return costReport{}, err
+	}
+	if section == "" {
+		return costReport{}, fmt.Errorf("no device sections found")
+	}
+	return report, nil
+}
+
+// reads the CostReport.txt of the result dir, returns nil if flank did not write one
+func readCostReport(resultDir string) (*costReport, error) {
+	pth := filepath.Join(resultDir, costReportFileName)
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, nil
+	}
+
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file, error: %s", err)
+		}
+	}()
+
+	report, err := parseCostReport(f)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (r costReport) outputs() [][2]string {
+	return [][2]string{
+		{"FLANK_BILLABLE_VIRTUAL_MINUTES", strconv.FormatInt(r.VirtualMinutes, 10)},
+		{"FLANK_BILLABLE_PHYSICAL_MINUTES", strconv.FormatInt(r.PhysicalMinutes, 10)},
+		{"FLANK_ESTIMATED_COST", strconv.FormatFloat(r.Cost, 'f', 2, 64)},
+	}
+}
+
+// costHistoryEntry is a run in the cost history file
+type costHistoryEntry struct {
+	Time            string  `json:"time"`
+	Branch          string  `json:"branch"`
+	Commit          string  `json:"commit"`
+	BuildNumber     string  `json:"build_number"`
+	TestName        string  `json:"test_name"`
+	VirtualMinutes  int64   `json:"billable_virtual_minutes"`
+	PhysicalMinutes int64   `json:"billable_physical_minutes"`
+	Cost            float64 `json:"estimated_cost"`
+}
+
+var costHistoryCSVHeader = []string{"time", "branch", "commit", "build_number", "test_name", "billable_virtual_minutes", "billable_physical_minutes", "estimated_cost"}
+
+func (e costHistoryEntry) csvRecord() []string {
+	return []string{
+		e.Time,
+		e.Branch,
+		e.Commit,
+		e.BuildNumber,
+		e.TestName,
+		strconv.FormatInt(e.VirtualMinutes, 10),
+		strconv.FormatInt(e.PhysicalMinutes, 10),
+		strconv.FormatFloat(e.Cost, 'f', 2, 64),
+	}
+}
+
+// appends the entry to the cost history file, the file is a JSON array if it has .json extension, a CSV otherwise
+func appendCostHistory(pth string, entry costHistoryEntry) error {
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return err
+	}
+
+	// an empty file is handled as a new one
+	var hasEntries bool
+	if fInf, err := os.Stat(pth); err == nil {
+		hasEntries = fInf.Size() > 0
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if strings.EqualFold(filepath.Ext(pth), ".json") {
+		var entries []costHistoryEntry
+		if hasEntries {
+			data, err := fileutil.ReadBytesFromFile(pth)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &entries); err != nil {
+				return fmt.Errorf("failed to parse cost history, error: %s", err)
+			}
+		}
+		data, err := json.MarshalIndent(append(entries, entry), "", "  ")
+		if err != nil {
+			return err
+		}
+		return fileutil.WriteBytesToFile(pth, data)
+	}
+
+	f, err := os.OpenFile(pth, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file, error: %s", err)
+		}
+	}()
+
+	w := csv.NewWriter(f)
+	if !hasEntries {
+		if err := w.Write(costHistoryCSVHeader); err != nil {
+			return err
+		}
+	}
+	if err := w.Write(entry.csvRecord()); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
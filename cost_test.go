@@ -0,0 +1,121 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_parseCostReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  string
+		want    costReport
+		wantErr bool
+	}{
+		{
+			name: "virtual and physical devices",
+			report: `CostReport
+  Virtual devices
+    $0.03 for 2m
+
+  Physical devices
+    $5.42 for 1h 5m
+
+  Total
+    $5.45 for 1h 7m
+`,
+			want: costReport{VirtualMinutes: 2, PhysicalMinutes: 65, Cost: 5.45},
+		},
+		{
+			name: "without total",
+			report: `Virtual devices
+  $0.02 for 1m
+`,
+			want: costReport{VirtualMinutes: 1, Cost: 0.02},
+		},
+		{name: "not a cost report", report: "Uploading app.apk", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCostReport(strings.NewReader(tt.report))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCostReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseCostReport() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_appendCostHistory(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-cost-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []costHistoryEntry{
+		{Time: "2020-08-10T10:00:00Z", Branch: "main", Commit: "abc", BuildNumber: "1", TestName: "android - flank", VirtualMinutes: 2, Cost: 0.03},
+		{Time: "2020-08-11T10:00:00Z", Branch: "feature, with comma", Commit: "def", BuildNumber: "2", TestName: "android - flank", PhysicalMinutes: 65, Cost: 5.42},
+	}
+
+	tests := []struct {
+		name string
+		pth  string
+		want string
+	}{
+		{
+			name: "csv",
+			pth:  filepath.Join(tmpDir, "cache", "cost-history.csv"),
+			want: `time,branch,commit,build_number,test_name,billable_virtual_minutes,billable_physical_minutes,estimated_cost
+2020-08-10T10:00:00Z,main,abc,1,android - flank,2,0,0.03
+2020-08-11T10:00:00Z,"feature, with comma",def,2,android - flank,0,65,5.42
+`,
+		},
+		{
+			name: "json",
+			pth:  filepath.Join(tmpDir, "cost-history.json"),
+			want: `[
+  {
+    "time": "2020-08-10T10:00:00Z",
+    "branch": "main",
+    "commit": "abc",
+    "build_number": "1",
+    "test_name": "android - flank",
+    "billable_virtual_minutes": 2,
+    "billable_physical_minutes": 0,
+    "estimated_cost": 0.03
+  },
+  {
+    "time": "2020-08-11T10:00:00Z",
+    "branch": "feature, with comma",
+    "commit": "def",
+    "build_number": "2",
+    "test_name": "android - flank",
+    "billable_virtual_minutes": 0,
+    "billable_physical_minutes": 65,
+    "estimated_cost": 5.42
+  }
+]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, entry := range entries {
+				if err := appendCostHistory(tt.pth, entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := fileutil.ReadStringFromFile(tt.pth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("appendCostHistory() file content = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
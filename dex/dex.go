@@ -0,0 +1,263 @@
+// Package dex reads the classes and the annotated methods of Android DEX files,
+// which is enough to list the instrumentation tests of a test APK without the Android SDK.
+package dex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	headerSize       = 0x70
+	classDefItemSize = 0x20
+	methodIDItemSize = 0x08
+	accessAbstract   = 0x400
+	accessInterface  = 0x200
+	noIndex          = 0xffffffff
+	magicPrefix      = "dex\n"
+)
+
+// Class is a class defined in a DEX file
+type Class struct {
+	Name       string
+	SuperClass string
+	Abstract   bool
+	// Annotations are the fully qualified names of the class annotations
+	Annotations []string
+	// Methods are the annotated methods of the class
+	Methods []Method
+}
+
+// Method is an annotated method of a class
+type Method struct {
+	Name        string
+	Annotations []string
+}
+
+type reader struct {
+	data []byte
+}
+
+func (r reader) u32(off uint32) (uint32, error) {
+	if uint64(off)+4 > uint64(len(r.data)) {
+		return 0, fmt.Errorf("offset %#x out of range", off)
+	}
+	return binary.LittleEndian.Uint32(r.data[off:]), nil
+}
+
+// returns the unsigned LEB128 value at the offset and the offset after it
+func (r reader) uleb128(off uint32) (uint32, uint32, error) {
+	var value uint32
+	for i := uint32(0); i < 5; i++ {
+		if uint64(off+i) >= uint64(len(r.data)) {
+			return 0, 0, fmt.Errorf("offset %#x out of range", off+i)
+		}
+		b := r.data[off+i]
+		value |= uint32(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return value, off + i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid uleb128 at %#x", off)
+}
+
+type file struct {
+	reader
+	stringIDsSize, stringIDsOff uint32
+	typeIDsSize, typeIDsOff     uint32
+	methodIDsSize, methodIDsOff uint32
+	classDefsSize, classDefsOff uint32
+}
+
+// returns the string of the string_ids table, the MUTF-8 data is returned as is,
+// which matches UTF-8 for the class, method and annotation names
+func (f file) string(idx uint32) (string, error) {
+	if idx >= f.stringIDsSize {
+		return "", fmt.Errorf("string index %d out of range", idx)
+	}
+	dataOff, err := f.u32(f.stringIDsOff + idx*4)
+	if err != nil {
+		return "", err
+	}
+	_, start, err := f.uleb128(dataOff)
+	if err != nil {
+		return "", err
+	}
+	end := bytes.IndexByte(f.data[start:], 0)
+	if end == -1 {
+		return "", fmt.Errorf("unterminated string at %#x", start)
+	}
+	return string(f.data[start : int(start)+end]), nil
+}
+
+// returns the java name of the type, eg.: Lcom/example/Foo; -> com.example.Foo
+func (f file) typeName(idx uint32) (string, error) {
+	if idx >= f.typeIDsSize {
+		return "", fmt.Errorf("type index %d out of range", idx)
+	}
+	descriptorIdx, err := f.u32(f.typeIDsOff + idx*4)
+	if err != nil {
+		return "", err
+	}
+	descriptor, err := f.string(descriptorIdx)
+	if err != nil {
+		return "", err
+	}
+	return javaName(descriptor), nil
+}
+
+func javaName(descriptor string) string {
+	if strings.HasPrefix(descriptor, "L") && strings.HasSuffix(descriptor, ";") {
+		return strings.Replace(descriptor[1:len(descriptor)-1], "/", ".", -1)
+	}
+	return descriptor
+}
+
+func (f file) methodName(idx uint32) (string, error) {
+	if idx >= f.methodIDsSize {
+		return "", fmt.Errorf("method index %d out of range", idx)
+	}
+	nameIdx, err := f.u32(f.methodIDsOff + idx*methodIDItemSize + 4)
+	if err != nil {
+		return "", err
+	}
+	return f.string(nameIdx)
+}
+
+// returns the annotation type names of an annotation_set_item
+func (f file) annotationSet(off uint32) ([]string, error) {
+	if off == 0 {
+		return nil, nil
+	}
+	size, err := f.u32(off)
+	if err != nil {
+		return nil, err
+	}
+
+	var annotations []string
+	for i := uint32(0); i < size; i++ {
+		itemOff, err := f.u32(off + 4 + i*4)
+		if err != nil {
+			return nil, err
+		}
+		// annotation_item: visibility (ubyte), encoded_annotation: type_idx (uleb128), ...
+		typeIdx, _, err := f.uleb128(itemOff + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, err := f.typeName(typeIdx)
+		if err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, name)
+	}
+	return annotations, nil
+}
+
+// reads the class annotations and the annotated methods of an annotations_directory_item
+func (f file) annotationsDirectory(off uint32, class *Class) error {
+	classAnnotationsOff, err := f.u32(off)
+	if err != nil {
+		return err
+	}
+	if class.Annotations, err = f.annotationSet(classAnnotationsOff); err != nil {
+		return err
+	}
+
+	fieldsSize, err := f.u32(off + 4)
+	if err != nil {
+		return err
+	}
+	methodsSize, err := f.u32(off + 8)
+	if err != nil {
+		return err
+	}
+
+	methodsOff := off + 16 + fieldsSize*8
+	for i := uint32(0); i < methodsSize; i++ {
+		methodIdx, err := f.u32(methodsOff + i*8)
+		if err != nil {
+			return err
+		}
+		annotationsOff, err := f.u32(methodsOff + i*8 + 4)
+		if err != nil {
+			return err
+		}
+
+		name, err := f.methodName(methodIdx)
+		if err != nil {
+			return err
+		}
+		annotations, err := f.annotationSet(annotationsOff)
+		if err != nil {
+			return err
+		}
+		class.Methods = append(class.Methods, Method{Name: name, Annotations: annotations})
+	}
+	return nil
+}
+
+// Parse returns the classes defined in the DEX file
+func Parse(data []byte) ([]Class, error) {
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte(magicPrefix)) {
+		return nil, fmt.Errorf("not a dex file")
+	}
+
+	f := file{reader: reader{data: data}}
+	for _, field := range []struct {
+		off   uint32
+		value *uint32
+	}{
+		{0x38, &f.stringIDsSize}, {0x3c, &f.stringIDsOff},
+		{0x40, &f.typeIDsSize}, {0x44, &f.typeIDsOff},
+		{0x58, &f.methodIDsSize}, {0x5c, &f.methodIDsOff},
+		{0x60, &f.classDefsSize}, {0x64, &f.classDefsOff},
+	} {
+		value, err := f.u32(field.off)
+		if err != nil {
+			return nil, err
+		}
+		*field.value = value
+	}
+
+	var classes []Class
+	for i := uint32(0); i < f.classDefsSize; i++ {
+		off := f.classDefsOff + i*classDefItemSize
+
+		classIdx, err := f.u32(off)
+		if err != nil {
+			return nil, err
+		}
+		accessFlags, err := f.u32(off + 4)
+		if err != nil {
+			return nil, err
+		}
+		superclassIdx, err := f.u32(off + 8)
+		if err != nil {
+			return nil, err
+		}
+		annotationsOff, err := f.u32(off + 20)
+		if err != nil {
+			return nil, err
+		}
+
+		class := Class{Abstract: accessFlags&(accessAbstract|accessInterface) != 0}
+		if class.Name, err = f.typeName(classIdx); err != nil {
+			return nil, fmt.Errorf("class %d: %s", i, err)
+		}
+		if superclassIdx != noIndex {
+			if class.SuperClass, err = f.typeName(superclassIdx); err != nil {
+				return nil, fmt.Errorf("class %s: %s", class.Name, err)
+			}
+		}
+		if annotationsOff != 0 {
+			if err := f.annotationsDirectory(annotationsOff, &class); err != nil {
+				return nil, fmt.Errorf("class %s: %s", class.Name, err)
+			}
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
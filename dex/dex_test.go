@@ -0,0 +1,284 @@
+package dex
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type methodSpec struct {
+	name        string
+	annotations []string
+}
+
+type classSpec struct {
+	descriptor  string
+	super       string
+	flags       uint32
+	annotations []string
+	methods     []methodSpec
+}
+
+func uleb128(v uint32) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b = append(b, c|0x80)
+			continue
+		}
+		return append(b, c)
+	}
+}
+
+// builds a dex file with the tables the parser reads: strings, types, method ids, class defs and annotations
+func buildDex(classes []classSpec) []byte {
+	var strs []string
+	stringIdx := map[string]uint32{}
+	addString := func(s string) uint32 {
+		if idx, ok := stringIdx[s]; ok {
+			return idx
+		}
+		stringIdx[s] = uint32(len(strs))
+		strs = append(strs, s)
+		return stringIdx[s]
+	}
+	var types []uint32
+	typeIdx := map[string]uint32{}
+	addType := func(descriptor string) uint32 {
+		if idx, ok := typeIdx[descriptor]; ok {
+			return idx
+		}
+		typeIdx[descriptor] = uint32(len(types))
+		types = append(types, addString(descriptor))
+		return typeIdx[descriptor]
+	}
+	type methodID struct{ class, name uint32 }
+	var methods []methodID
+	for _, c := range classes {
+		addType(c.descriptor)
+		if c.super != "" {
+			addType(c.super)
+		}
+		for _, a := range c.annotations {
+			addType(a)
+		}
+		for _, m := range c.methods {
+			for _, a := range m.annotations {
+				addType(a)
+			}
+			methods = append(methods, methodID{class: typeIdx[c.descriptor], name: addString(m.name)})
+		}
+	}
+
+	stringIDsOff := uint32(0x70)
+	typeIDsOff := stringIDsOff + uint32(len(strs))*4
+	methodIDsOff := typeIDsOff + uint32(len(types))*4
+	classDefsOff := methodIDsOff + uint32(len(methods))*8
+	dataOff := classDefsOff + uint32(len(classes))*0x20
+
+	out := make([]byte, dataOff)
+	u32 := func(off, v uint32) { binary.LittleEndian.PutUint32(out[off:], v) }
+	appendU32 := func(v uint32) {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], v)
+		out = append(out, b[:]...)
+	}
+
+	copy(out, "dex\n035\x00")
+	u32(0x38, uint32(len(strs)))
+	u32(0x3c, stringIDsOff)
+	u32(0x40, uint32(len(types)))
+	u32(0x44, typeIDsOff)
+	u32(0x58, uint32(len(methods)))
+	u32(0x5c, methodIDsOff)
+	u32(0x60, uint32(len(classes)))
+	u32(0x64, classDefsOff)
+
+	for i, s := range strs {
+		u32(stringIDsOff+uint32(i)*4, uint32(len(out)))
+		out = append(out, uleb128(uint32(len(s)))...)
+		out = append(out, s...)
+		out = append(out, 0)
+	}
+	for i, stringIdx := range types {
+		u32(typeIDsOff+uint32(i)*4, stringIdx)
+	}
+	for i, m := range methods {
+		binary.LittleEndian.PutUint16(out[methodIDsOff+uint32(i)*8:], uint16(m.class))
+		u32(methodIDsOff+uint32(i)*8+4, m.name)
+	}
+
+	annotationSet := func(annotations []string) uint32 {
+		if len(annotations) == 0 {
+			return 0
+		}
+		var items []uint32
+		for _, a := range annotations {
+			items = append(items, uint32(len(out)))
+			out = append(out, 1)
+			out = append(out, uleb128(typeIdx[a])...)
+			out = append(out, uleb128(0)...)
+		}
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+		off := uint32(len(out))
+		appendU32(uint32(len(items)))
+		for _, item := range items {
+			appendU32(item)
+		}
+		return off
+	}
+
+	methodIdx := uint32(0)
+	for i, c := range classes {
+		classSet := annotationSet(c.annotations)
+		var methodSets []uint32
+		for _, m := range c.methods {
+			methodSets = append(methodSets, annotationSet(m.annotations))
+		}
+
+		directoryOff := uint32(0)
+		if classSet != 0 || len(c.methods) > 0 {
+			directoryOff = uint32(len(out))
+			appendU32(classSet)
+			appendU32(0)
+			appendU32(uint32(len(c.methods)))
+			appendU32(0)
+			for _, set := range methodSets {
+				appendU32(methodIdx)
+				appendU32(set)
+				methodIdx++
+			}
+		}
+
+		off := classDefsOff + uint32(i)*0x20
+		u32(off, typeIdx[c.descriptor])
+		u32(off+4, c.flags)
+		super := uint32(noIndex)
+		if c.super != "" {
+			super = typeIdx[c.super]
+		}
+		u32(off+8, super)
+		u32(off+20, directoryOff)
+	}
+	return out
+}
+
+var testClasses = []classSpec{
+	{
+		descriptor: "Lcom/example/BaseTest;",
+		super:      "Ljava/lang/Object;",
+		flags:      accessAbstract,
+		methods:    []methodSpec{{name: "testBase", annotations: []string{"Lorg/junit/Test;"}}},
+	},
+	{
+		descriptor:  "Lcom/example/MainTest;",
+		super:       "Lcom/example/BaseTest;",
+		annotations: []string{"Lorg/junit/runner/RunWith;", "Landroidx/test/filters/LargeTest;"},
+		methods: []methodSpec{
+			{name: "testB", annotations: []string{"Lorg/junit/Test;", "Lorg/junit/Ignore;"}},
+			{name: "setUp", annotations: []string{"Lorg/junit/Before;"}},
+			{name: "testA", annotations: []string{"Lorg/junit/Test;"}},
+		},
+	},
+	{descriptor: "Lcom/example/Helper;", super: "Ljava/lang/Object;"},
+}
+
+func TestParse(t *testing.T) {
+	classes, err := Parse(buildDex(testClasses))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Class{
+		{Name: "com.example.BaseTest", SuperClass: "java.lang.Object", Abstract: true, Methods: []Method{{Name: "testBase", Annotations: []string{"org.junit.Test"}}}},
+		{
+			Name:        "com.example.MainTest",
+			SuperClass:  "com.example.BaseTest",
+			Annotations: []string{"org.junit.runner.RunWith", "androidx.test.filters.LargeTest"},
+			Methods: []Method{
+				{Name: "testB", Annotations: []string{"org.junit.Test", "org.junit.Ignore"}},
+				{Name: "setUp", Annotations: []string{"org.junit.Before"}},
+				{Name: "testA", Annotations: []string{"org.junit.Test"}},
+			},
+		},
+		{Name: "com.example.Helper", SuperClass: "java.lang.Object"},
+	}
+	if !reflect.DeepEqual(classes, want) {
+		t.Errorf("Parse() = %+v, want %+v", classes, want)
+	}
+
+	if _, err := Parse([]byte("PK\x03\x04")); err == nil {
+		t.Error("Parse() of a non dex file error = nil")
+	}
+	truncated := buildDex(testClasses)
+	if _, err := Parse(truncated[:0x90]); err == nil {
+		t.Error("Parse() of a truncated dex file error = nil")
+	}
+}
+
+func TestReadAPKAndListTests(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-dex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Log(err)
+		}
+	}()
+
+	apkPath := filepath.Join(tmpDir, "test.apk")
+	f, err := os.Create(apkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for name, content := range map[string][]byte{
+		"AndroidManifest.xml": []byte("binary manifest"),
+		"classes.dex":         buildDex(testClasses[:1]),
+		"classes2.dex":        buildDex(testClasses[1:]),
+		"lib/classes3.dex":    []byte("not loaded"),
+	} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	classes, err := ReadAPK(apkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TestClass{
+		{
+			Name:        "com.example.MainTest",
+			Annotations: []string{"org.junit.runner.RunWith", "androidx.test.filters.LargeTest"},
+			Methods: []TestMethod{
+				{Name: "testA", Annotations: []string{"org.junit.Test"}},
+				{Name: "testB", Annotations: []string{"org.junit.Test", "org.junit.Ignore"}},
+				{Name: "testBase", Annotations: []string{"org.junit.Test"}},
+			},
+		},
+	}
+	if got := ListTests(classes); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListTests() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package dex
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// TestAnnotation marks a JUnit4 test method
+const TestAnnotation = "org.junit.Test"
+
+var dexFilePattern = regexp.MustCompile(`^classes[0-9]*\.dex$`)
+
+// TestClass is a runnable class with test methods, the methods include the ones inherited from its superclasses
+type TestClass struct {
+	Name        string       `json:"name"`
+	Annotations []string     `json:"annotations"`
+	Methods     []TestMethod `json:"methods"`
+}
+
+// TestMethod is a method annotated with @Test
+type TestMethod struct {
+	Name        string   `json:"name"`
+	Annotations []string `json:"annotations"`
+}
+
+// ReadAPK returns the classes of every classes*.dex in the APK
+func ReadAPK(pth string) ([]Class, error) {
+	r, err := zip.OpenReader(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	var classes []Class
+	var found bool
+	for _, f := range r.File {
+		if !dexFilePattern.MatchString(path.Base(f.Name)) || path.Dir(f.Name) != "." {
+			continue
+		}
+		found = true
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		if closeErr := rc.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s, error: %s", f.Name, err)
+		}
+
+		dexClasses, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s, error: %s", f.Name, err)
+		}
+		classes = append(classes, dexClasses...)
+	}
+	if !found {
+		return nil, fmt.Errorf("no classes.dex found in %s", pth)
+	}
+	return classes, nil
+}
+
+// returns an empty slice instead of nil, so that it is encoded as an empty JSON array
+func nonNil(annotations []string) []string {
+	if annotations == nil {
+		return []string{}
+	}
+	return annotations
+}
+
+func hasAnnotation(annotations []string, annotation string) bool {
+	for _, a := range annotations {
+		if a == annotation {
+			return true
+		}
+	}
+	return false
+}
+
+// ListTests returns the non abstract classes with @Test methods sorted by name, JUnit4 runs the test methods
+// of the superclasses too, so they are listed under every subclass
+func ListTests(classes []Class) []TestClass {
+	byName := map[string]Class{}
+	for _, class := range classes {
+		byName[class.Name] = class
+	}
+
+	var tests []TestClass
+	for _, class := range classes {
+		if class.Abstract {
+			continue
+		}
+
+		var methods []TestMethod
+		seen := map[string]bool{}
+		visited := map[string]bool{}
+		// walk up the hierarchy, an annotated overriding method hides the one of the superclass
+		for c, ok := class, true; ok && !visited[c.Name]; c, ok = byName[c.SuperClass] {
+			visited[c.Name] = true
+			for _, method := range c.Methods {
+				if seen[method.Name] {
+					continue
+				}
+				seen[method.Name] = true
+				if hasAnnotation(method.Annotations, TestAnnotation) {
+					methods = append(methods, TestMethod{Name: method.Name, Annotations: nonNil(method.Annotations)})
+				}
+			}
+		}
+		if len(methods) == 0 {
+			continue
+		}
+
+		sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+		tests = append(tests, TestClass{Name: class.Name, Annotations: nonNil(class.Annotations), Methods: methods})
+	}
+	sort.Slice(tests, func(i, j int) bool { return tests[i].Name < tests[j].Name })
+	return tests
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	durationCacheFileSuffix = "_durations.json"
+	// the baseline of a test or shard is the median of its latest durations
+	durationWindowSize = 10
+	// durations are compared only with a baseline of at least this many runs, so that a single run does not set it
+	durationMinSamples = 3
+)
+
+// durationThresholds tells how much slower a test or shard has to be than its baseline to count as a regression,
+// both thresholds have to be exceeded, 0 disables a threshold
+type durationThresholds struct {
+	Percent int
+	Seconds int
+}
+
+// durationCache keeps the latest durations of the tests (class#method) and shards of a test run name
+type durationCache struct {
+	Tests  map[string][]float64 `json:"tests"`
+	Shards map[string][]float64 `json:"shards"`
+}
+
+// durationRegression is a test or shard which took significantly longer than its baseline
+type durationRegression struct {
+	Name            string  `json:"name"`
+	BaselineSeconds float64 `json:"baseline_seconds"`
+	Seconds         float64 `json:"seconds"`
+}
+
+func (r durationRegression) String() string {
+	return fmt.Sprintf("%s (%s -> %s, +%.0f%%)", r.Name, formatSeconds(r.BaselineSeconds), formatSeconds(r.Seconds), (r.Seconds/r.BaselineSeconds-1)*100)
+}
+
+// durationReport separates the wall time of the flank run from the time the tests ran on Test Lab,
+// the regressions are nil if no duration cache is kept
+type durationReport struct {
+	RunSeconds      float64              `json:"run_seconds"`
+	TestLabSeconds  float64              `json:"test_lab_seconds"`
+	Shards          int                  `json:"shards"`
+	RegressedTests  []durationRegression `json:"regressed_tests,omitempty"`
+	RegressedShards []durationRegression `json:"regressed_shards,omitempty"`
+}
+
+// returns the cache file of the test run name, the same name has the same durations across builds
+func durationCachePath(dir, testName string) string {
+	return filepath.Join(dir, unsafeFileNameChars.ReplaceAllString(testName, "_")+durationCacheFileSuffix)
+}
+
+// returns the average duration of the passed and flaky tests by class#method, failed tests are left out
+// as a failure can end a test early
+func collectTestDurations(suites junitTestSuites) map[string]float64 {
+	sums := map[string]float64{}
+	counts := map[string]int{}
+	for _, suite := range suites.Suites {
+		for _, tc := range suite.TestCases {
+			if tc.skipped() || tc.failed() || tc.errored() {
+				continue
+			}
+			name := tc.ClassName + "#" + tc.Name
+			sums[name] += parseJUnitTime(tc.Time)
+			counts[name]++
+		}
+	}
+
+	durations := map[string]float64{}
+	for name, sum := range sums {
+		durations[name] = sum / float64(counts[name])
+	}
+	return durations
+}
+
+// returns the durations of the shards by {shard}/{device} of the canonical report suites
+func collectShardDurations(suites junitTestSuites) map[string]float64 {
+	durations := map[string]float64{}
+	for _, suite := range suites.Suites {
+		name := path.Join(suite.property(junitPropertyShard), suite.property(junitPropertyDevice))
+		if name == "" {
+			name = suite.Name
+		}
+		durations[name] += summarizeTests(junitTestSuites{Suites: []junitTestSuite{suite}}).Time
+	}
+	return durations
+}
+
+// returns the longest shard duration, the shards run in parallel on Test Lab
+func longestDuration(durations map[string]float64) float64 {
+	var longest float64
+	for _, d := range durations {
+		if d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+func medianDuration(samples []float64) float64 {
+	sorted := append([]float64{}, samples...)
+	sort.Float64s(sorted)
+	if n := len(sorted); n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[len(sorted)/2]
+}
+
+// a duration regressed if it exceeds the baseline by every enabled (> 0) threshold,
+// nothing regresses if both thresholds are disabled
+func (t durationThresholds) regressed(baseline, seconds float64) bool {
+	if t.Percent <= 0 && t.Seconds <= 0 {
+		return false
+	}
+	if baseline <= 0 || seconds <= baseline {
+		return false
+	}
+	if t.Percent > 0 && seconds < baseline*(1+float64(t.Percent)/100) {
+		return false
+	}
+	return t.Seconds <= 0 || seconds-baseline >= float64(t.Seconds)
+}
+
+// compares the durations with the baseline of their window, then adds them to the window
+func updateDurationWindow(windows map[string][]float64, durations map[string]float64, thresholds durationThresholds) []durationRegression {
+	regressions := []durationRegression{}
+	for name, seconds := range durations {
+		window := windows[name]
+		if len(window) >= durationMinSamples {
+			if baseline := medianDuration(window); thresholds.regressed(baseline, seconds) {
+				regressions = append(regressions, durationRegression{Name: name, BaselineSeconds: baseline, Seconds: seconds})
+			}
+		}
+
+		window = append(window, seconds)
+		if len(window) > durationWindowSize {
+			window = window[len(window)-durationWindowSize:]
+		}
+		windows[name] = window
+	}
+	sort.Slice(regressions, func(i, j int) bool { return regressions[i].Name < regressions[j].Name })
+	return regressions
+}
+
+// compares the test and shard durations with their rolling baseline then adds them to the cache
+func (c *durationCache) update(tests, shards map[string]float64, thresholds durationThresholds) (regressedTests, regressedShards []durationRegression) {
+	if c.Tests == nil {
+		c.Tests = map[string][]float64{}
+	}
+	if c.Shards == nil {
+		c.Shards = map[string][]float64{}
+	}
+	return updateDurationWindow(c.Tests, tests, thresholds), updateDurationWindow(c.Shards, shards, thresholds)
+}
+
+// returns nil if the cache file does not exist
+func readDurationCache(pth string) (*durationCache, error) {
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, nil
+	}
+
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	var cache durationCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("failed to parse %s, error: %s", pth, err)
+	}
+	return &cache, nil
+}
+
+func writeDurationCache(pth string, cache *durationCache) error {
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, data)
+}
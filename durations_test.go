@@ -0,0 +1,106 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_durationThresholds_regressed(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds durationThresholds
+		baseline   float64
+		seconds    float64
+		want       bool
+	}{
+		{name: "both exceeded", thresholds: durationThresholds{Percent: 50, Seconds: 10}, baseline: 20, seconds: 30, want: true},
+		{name: "only the percent exceeded", thresholds: durationThresholds{Percent: 50, Seconds: 10}, baseline: 2, seconds: 5},
+		{name: "only the seconds exceeded", thresholds: durationThresholds{Percent: 50, Seconds: 10}, baseline: 100, seconds: 120},
+		{name: "percent disabled", thresholds: durationThresholds{Seconds: 10}, baseline: 100, seconds: 110, want: true},
+		{name: "seconds disabled", thresholds: durationThresholds{Percent: 50}, baseline: 2, seconds: 3, want: true},
+		{name: "both disabled", thresholds: durationThresholds{}, baseline: 2, seconds: 30},
+		{name: "faster", thresholds: durationThresholds{Percent: 50}, baseline: 2, seconds: 1},
+		{name: "no baseline", thresholds: durationThresholds{}, baseline: 0, seconds: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.thresholds.regressed(tt.baseline, tt.seconds); got != tt.want {
+				t.Errorf("regressed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_durationCache_update(t *testing.T) {
+	cache := &durationCache{}
+	thresholds := durationThresholds{Percent: 50, Seconds: 5}
+
+	for i := 0; i < durationMinSamples; i++ {
+		// a baseline of less than durationMinSamples runs is not compared
+		tests, shards := cache.update(map[string]float64{"A#a": 10, "A#b": 10 + float64(i*10)}, map[string]float64{"shard_0/Pixel2": 60}, thresholds)
+		if len(tests) != 0 || len(shards) != 0 {
+			t.Fatalf("update() of run %d = %v, %v, want no regressions", i, tests, shards)
+		}
+	}
+
+	tests, shards := cache.update(map[string]float64{"A#a": 16, "A#b": 21, "A#new": 100}, map[string]float64{"shard_0/Pixel2": 120}, thresholds)
+	wantTests := []durationRegression{{Name: "A#a", BaselineSeconds: 10, Seconds: 16}}
+	if !reflect.DeepEqual(tests, wantTests) {
+		t.Errorf("update() regressed tests = %v, want %v", tests, wantTests)
+	}
+	wantShards := []durationRegression{{Name: "shard_0/Pixel2", BaselineSeconds: 60, Seconds: 120}}
+	if !reflect.DeepEqual(shards, wantShards) {
+		t.Errorf("update() regressed shards = %v, want %v", shards, wantShards)
+	}
+
+	for i := 0; i < durationWindowSize; i++ {
+		cache.update(map[string]float64{"A#a": 10}, nil, thresholds)
+	}
+	if got := len(cache.Tests["A#a"]); got != durationWindowSize {
+		t.Errorf("window size = %d, want %d", got, durationWindowSize)
+	}
+}
+
+func Test_collectShardDurations(t *testing.T) {
+	suites := junitTestSuites{Suites: []junitTestSuite{
+		{Name: "Pixel2-29-en-portrait", Time: "42.5", Properties: []junitProperty{{Name: junitPropertyDevice, Value: "Pixel2-29-en-portrait"}, {Name: junitPropertyShard, Value: "shard_0"}}},
+		{Name: "Pixel2-29-en-portrait", Time: "12", Properties: []junitProperty{{Name: junitPropertyDevice, Value: "Pixel2-29-en-portrait"}, {Name: junitPropertyShard, Value: "shard_1"}}},
+		{Name: "matrix-1", Time: "", TestCases: []junitTestCase{{Name: "a", ClassName: "A", Time: "3"}}},
+	}}
+
+	got := collectShardDurations(suites)
+	want := map[string]float64{"shard_0/Pixel2-29-en-portrait": 42.5, "shard_1/Pixel2-29-en-portrait": 12, "matrix-1": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectShardDurations() = %v, want %v", got, want)
+	}
+	if longest := longestDuration(got); longest != 42.5 {
+		t.Errorf("longestDuration() = %v, want 42.5", longest)
+	}
+}
+
+func Test_durationCache_readWrite(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-duration-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pth := durationCachePath(filepath.Join(tmpDir, "cache"), "android - flank")
+
+	if cache, err := readDurationCache(pth); err != nil || cache != nil {
+		t.Fatalf("readDurationCache() of a missing file = %v, %v", cache, err)
+	}
+
+	want := &durationCache{Tests: map[string][]float64{"A#a": {1, 2}}, Shards: map[string][]float64{"shard_0": {3}}}
+	if err := writeDurationCache(pth, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readDurationCache(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readDurationCache() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"gopkg.in/yaml.v2"
+)
+
+const effectiveConfigFileName = "flank.yml"
+
+// effectiveConfig is the flank config yml the step runs flank with, the step's features override
+// the values of the user's config in it, the order and the other values of the config are kept
+type effectiveConfig struct {
+	root     yaml.MapSlice
+	modified bool
+}
+
+func readEffectiveConfig(configYMLPath string) (*effectiveConfig, error) {
+	ymlBytes, err := fileutil.ReadBytesFromFile(configYMLPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var root yaml.MapSlice
+	if err := yaml.Unmarshal(ymlBytes, &root); err != nil {
+		return nil, err
+	}
+	return &effectiveConfig{root: root}, nil
+}
+
+func indexOf(slice yaml.MapSlice, key string) int {
+	for i, item := range slice {
+		if k, ok := item.Key.(string); ok && k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// returns the section (eg.: gcloud, flank) of the config, nil if the config has no such section
+func (c *effectiveConfig) section(name string) yaml.MapSlice {
+	if idx := indexOf(c.root, name); idx != -1 {
+		if section, ok := c.root[idx].Value.(yaml.MapSlice); ok {
+			return section
+		}
+	}
+	return nil
+}
+
+// returns the value of the key in the given section
+func (c *effectiveConfig) get(section, key string) (interface{}, bool) {
+	values := c.section(section)
+	if idx := indexOf(values, key); idx != -1 {
+		return values[idx].Value, true
+	}
+	return nil, false
+}
+
+// sets the value of the key in the given section, the section is created if it does not exist
+func (c *effectiveConfig) set(section, key string, value interface{}) {
+	values := c.section(section)
+	if idx := indexOf(values, key); idx != -1 {
+		values[idx].Value = value
+	} else {
+		values = append(values, yaml.MapItem{Key: key, Value: value})
+	}
+
+	if idx := indexOf(c.root, section); idx != -1 {
+		c.root[idx].Value = values
+	} else {
+		c.root = append(c.root, yaml.MapItem{Key: section, Value: values})
+	}
+	c.modified = true
+}
+
+func (c *effectiveConfig) write(pth string) error {
+	data, err := yaml.Marshal(c.root)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, data)
+}
+
+// returns the config flank runs with: the user's config or, if the step modified it, the written effective config
+func (c *effectiveConfig) path(userConfigPath string) (string, error) {
+	if !c.modified {
+		return userConfigPath, nil
+	}
+	configDir, err := pathutil.NormalizedOSTempDirPath("flank-config")
+	if err != nil {
+		return "", err
+	}
+	pth := filepath.Join(configDir, effectiveConfigFileName)
+	return pth, c.write(pth)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_effectiveConfig(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-effective-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := writeTestFile(t, tmpDir, "flank.yml", `gcloud:
+  app: ./app.apk
+  test: ./test.apk
+  test-targets-for-shard:
+  - class com.example.OldTest
+  device:
+  - model: NexusLowRes
+    version: 28
+`)
+
+	cfg, err := readEffectiveConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.modified {
+		t.Error("modified = true before setting a value")
+	}
+	if value, ok := cfg.get("gcloud", "app"); !ok || value != "./app.apk" {
+		t.Errorf("get() = %v, %v, want ./app.apk", value, ok)
+	}
+
+	cfg.set("gcloud", testTargetsForShardKey, []string{"class com.example.MainTest#testA", "class com.example.MainTest#testB"})
+	cfg.set("flank", "max-test-shards", 2)
+	if !cfg.modified {
+		t.Error("modified = false after setting a value")
+	}
+
+	pth := filepath.Join(tmpDir, effectiveConfigFileName)
+	if err := cfg.write(pth); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fileutil.ReadStringFromFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `gcloud:
+  app: ./app.apk
+  test: ./test.apk
+  test-targets-for-shard:
+  - class com.example.MainTest#testA
+  - class com.example.MainTest#testB
+  device:
+  - model: NexusLowRes
+    version: 28
+flank:
+  max-test-shards: 2
+`
+	if got != want {
+		t.Errorf("effective config = %s, want %s", got, want)
+	}
+}
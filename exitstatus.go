@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/hashicorp/go-version"
+)
+
+// exitCategory is the classification of flank's exit status
+type exitCategory string
+
+const (
+	exitCategorySuccess        exitCategory = "success"
+	exitCategoryTestFailure    exitCategory = "test_failure"
+	exitCategoryInconclusive   exitCategory = "inconclusive"
+	exitCategoryInfrastructure exitCategory = "infrastructure"
+	exitCategoryConfig         exitCategory = "config"
+	exitCategoryCancelled      exitCategory = "cancelled"
+	exitCategoryTimeout        exitCategory = "timeout"
+	exitCategoryGeneral        exitCategory = "general_failure"
+	exitCategoryUnknown        exitCategory = "unknown"
+)
+
+var exitCategories = []exitCategory{
+	exitCategorySuccess,
+	exitCategoryTestFailure,
+	exitCategoryInconclusive,
+	exitCategoryInfrastructure,
+	exitCategoryConfig,
+	exitCategoryCancelled,
+	exitCategoryTimeout,
+	exitCategoryGeneral,
+	exitCategoryUnknown,
+}
+
+type exitCodeInfo struct {
+	Category    exitCategory
+	Description string
+	// Refine is set if the last flank error decides the category, eg. flank exits with the
+	// unexpected error status on a timeout or a cancelled matrix
+	Refine bool
+}
+
+// exitCodeCatalog describes the exit codes of the flank versions matching the constraint
+type exitCodeCatalog struct {
+	Constraint string
+	Codes      map[int]exitCodeInfo
+}
+
+const (
+	descriptionGeneralFailure   = "A general failure occurred. Possible causes include: a filename that does not exist or an HTTP/network error."
+	descriptionConfigFailure    = "Usually indicates missing or wrong usage of flags, incorrect parameters, errors in config files."
+	descriptionUnexpectedError  = "At least one matrix not finished (usually a FTL internal error) or unexpected error occurred, eg.: the run timed out or a matrix was canceled."
+	descriptionTestFailure      = "At least one test failed or was inconclusive."
+	descriptionInconclusive     = "Firebase Test Lab could not determine if the test matrix passed or failed, because of an unexpected error."
+	descriptionIncompatibleTest = "The test environment for this test execution is not supported because of incompatible test dimensions. This error might occur if the selected Android API level is not supported by the selected device type."
+	descriptionCanceled         = "The test matrix was canceled by the user."
+	descriptionInfrastructure   = "A test infrastructure error occurred."
+)
+
+// the catalogs are ordered from the newest flank versions to the oldest ones,
+// a version which can not be parsed (eg. a snapshot build) uses the first one.
+// flank 20.05.0 moved the unfinished matrix and unexpected error status from 1 to 3,
+// 1 became the status of general failures; the test result statuses follow gcloud's in both.
+var exitCodeCatalogs = []exitCodeCatalog{
+	{
+		Constraint: ">= 20.05.0",
+		Codes: map[int]exitCodeInfo{
+			0:  {exitCategorySuccess, "", false},
+			1:  {exitCategoryGeneral, descriptionGeneralFailure, true},
+			2:  {exitCategoryConfig, descriptionConfigFailure, false},
+			3:  {exitCategoryInfrastructure, descriptionUnexpectedError, true},
+			10: {exitCategoryTestFailure, descriptionTestFailure, false},
+			15: {exitCategoryInconclusive, descriptionInconclusive, false},
+			18: {exitCategoryConfig, descriptionIncompatibleTest, false},
+			19: {exitCategoryCancelled, descriptionCanceled, false},
+			20: {exitCategoryInfrastructure, descriptionInfrastructure, false},
+		},
+	},
+	{
+		Constraint: "< 20.05.0",
+		Codes: map[int]exitCodeInfo{
+			0:  {exitCategorySuccess, "", false},
+			1:  {exitCategoryInfrastructure, descriptionUnexpectedError, true},
+			2:  {exitCategoryConfig, descriptionConfigFailure, false},
+			10: {exitCategoryTestFailure, descriptionTestFailure, false},
+			15: {exitCategoryInconclusive, descriptionInconclusive, false},
+			18: {exitCategoryConfig, descriptionIncompatibleTest, false},
+			19: {exitCategoryCancelled, descriptionCanceled, false},
+			20: {exitCategoryInfrastructure, descriptionInfrastructure, false},
+		},
+	},
+}
+
+// flank's error types, used when the exit status is not in the catalog or the catalog refines it by the error
+var flankErrorCategories = map[string]exitCategory{
+	"FlankConfigurationError":        exitCategoryConfig,
+	"YmlValidationError":             exitCategoryConfig,
+	"IncompatibleTestDimensionError": exitCategoryConfig,
+	"FlankTimeoutError":              exitCategoryTimeout,
+	"MatrixCanceledError":            exitCategoryCancelled,
+	"FTLError":                       exitCategoryInfrastructure,
+	"InfrastructureError":            exitCategoryInfrastructure,
+	"FlankGeneralError":              exitCategoryGeneral,
+}
+
+// matches only flank's own error types at the start of the line (after the timestamp of the log file),
+// so the errors printed by the tests do not count
+var flankErrorPattern = func() *regexp.Regexp {
+	var types []string
+	for errorType := range flankErrorCategories {
+		types = append(types, errorType)
+	}
+	sort.Strings(types)
+	return regexp.MustCompile(`^(?:\[[0-9: -]+\]\s*)?(?:Exception in thread "\w+"\s+)?(?:[\w.]+\.)?(` + strings.Join(types, "|") + `):\s*(.+)$`)
+}()
+
+// flankError is the last error printed by flank
+type flankError struct {
+	Type    string
+	Message string
+}
+
+// returns the last error line of flank's output or nil if there is none
+func parseFlankError(r io.Reader) (*flankError, error) {
+	var last *flankError
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(ansiEscapePattern.ReplaceAllString(scanner.Text(), ""))
+		if match := flankErrorPattern.FindStringSubmatch(line); match != nil {
+			last = &flankError{Type: match[1], Message: strings.TrimSpace(match[2])}
+		}
+	}
+	return last, scanner.Err()
+}
+
+// returns the last error line of the flank log file or nil if there is none
+func readFlankError(logPath string) (*flankError, error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file, error: %s", err)
+		}
+	}()
+	return parseFlankError(f)
+}
+
+// returns the exit code catalog of the given flank version
+func findExitCodeCatalog(flankVersion string) exitCodeCatalog {
+	v, err := version.NewVersion(flankVersion)
+	if err != nil {
+		return exitCodeCatalogs[0]
+	}
+
+	for _, catalog := range exitCodeCatalogs {
+		constraint, err := version.NewConstraint(catalog.Constraint)
+		if err != nil {
+			continue
+		}
+		if constraint.Check(v) {
+			return catalog
+		}
+	}
+	return exitCodeCatalogs[0]
+}
+
+// exitClassification describes flank's exit status
+type exitClassification struct {
+	Status      int
+	Category    exitCategory
+	Description string
+	FlankError  *flankError
+}
+
+// classifies the exit status based on the catalog of the flank version which ran,
+// the last error printed by flank (can be nil) refines the ambiguous and unknown exit statuses
+func classifyExitStatus(flankVersion string, exitStatus int, flankErr *flankError) exitClassification {
+	classification := exitClassification{Status: exitStatus, Category: exitCategoryUnknown}
+	info, known := findExitCodeCatalog(flankVersion).Codes[exitStatus]
+	if known {
+		classification.Category = info.Category
+		classification.Description = info.Description
+	}
+	if exitStatus == 0 || flankErr == nil {
+		return classification
+	}
+
+	classification.FlankError = flankErr
+	if !known || info.Refine {
+		if category, ok := flankErrorCategories[flankErr.Type]; ok {
+			classification.Category = category
+		}
+	}
+	if classification.Description == "" {
+		classification.Description = flankErr.Message
+	}
+	return classification
+}
+
+// exitAction is the step result an exit code policy rule maps to
+type exitAction string
+
+const (
+	exitActionSuccess exitAction = "success"
+	exitActionWarning exitAction = "warning"
+	exitActionFailure exitAction = "failure"
+)
+
+// JUnit derived exit code policy conditions
+const (
+	// every failed test passed on a rerun
+	conditionFlakyPassed = "flaky_passed"
+	// the JUnit report has no test cases
+	conditionNoTests = "no_tests"
+)
+
+// exitPolicyRule maps a condition to a step result, the condition is either an exit status,
+// an exit category or a JUnit derived condition
+type exitPolicyRule struct {
+	Condition string
+	Action    exitAction
+}
+
+func isKnownCondition(condition string) bool {
+	if _, err := strconv.Atoi(condition); err == nil {
+		return true
+	}
+	for _, category := range exitCategories {
+		if condition == string(category) {
+			return true
+		}
+	}
+	return condition == conditionFlakyPassed || condition == conditionNoTests
+}
+
+// parses the condition:action rules of the exit_code_policy input
+func parseExitCodePolicy(rules []string) ([]exitPolicyRule, error) {
+	var policy []exitPolicyRule
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		split := strings.Split(rule, ":")
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid rule (%s), expected format: condition:action", rule)
+		}
+		condition, action := strings.TrimSpace(split[0]), exitAction(strings.TrimSpace(split[1]))
+
+		if !isKnownCondition(condition) {
+			return nil, fmt.Errorf("invalid rule (%s), unknown condition: %s", rule, condition)
+		}
+		switch action {
+		case exitActionSuccess, exitActionWarning, exitActionFailure:
+		default:
+			return nil, fmt.Errorf("invalid rule (%s), action should be one of: success, warning, failure", rule)
+		}
+
+		policy = append(policy, exitPolicyRule{Condition: condition, Action: action})
+	}
+	return policy, nil
+}
+
+func (r exitPolicyRule) matches(classification exitClassification, summary *testSummary) bool {
+	switch r.Condition {
+	case conditionFlakyPassed:
+		return summary != nil && summary.Flaky > 0 && summary.Failed == 0 && summary.Errors == 0
+	case conditionNoTests:
+		return summary == nil || summary.Total == 0
+	case string(classification.Category):
+		return true
+	default:
+		return r.Condition == strconv.Itoa(classification.Status)
+	}
+}
+
+// returns the action of the first matching rule, without a match the exit status decides;
+// summary is nil if the run has no test results
+func applyExitCodePolicy(policy []exitPolicyRule, classification exitClassification, summary *testSummary) (exitAction, *exitPolicyRule) {
+	for i, rule := range policy {
+		if rule.matches(classification, summary) {
+			return rule.Action, &policy[i]
+		}
+	}
+
+	if classification.Status == 0 {
+		return exitActionSuccess, nil
+	}
+	return exitActionFailure, nil
+}
+
+// returns the exit code of the step for the given policy action
+func stepExitCode(action exitAction, exitStatus int) int {
+	if action != exitActionFailure {
+		return 0
+	}
+	if exitStatus == 0 {
+		return 1
+	}
+	return exitStatus
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_classifyExitStatus(t *testing.T) {
+	tests := []struct {
+		name            string
+		flankVersion    string
+		exitStatus      int
+		flankErr        *flankError
+		want            exitCategory
+		wantDescription string
+	}{
+		{name: "success", flankVersion: "v20.08.0", exitStatus: 0, want: exitCategorySuccess},
+		{name: "test failure", flankVersion: "v20.08.0", exitStatus: 10, want: exitCategoryTestFailure, wantDescription: "At least one test failed or was inconclusive."},
+		{name: "test failure of an old flank", flankVersion: "v8.1.0", exitStatus: 10, want: exitCategoryTestFailure, wantDescription: descriptionTestFailure},
+		{name: "unparseable version uses the newest catalog", flankVersion: "snapshot", exitStatus: 3, want: exitCategoryInfrastructure, wantDescription: descriptionUnexpectedError},
+		{name: "unexpected error before 20.05.0", flankVersion: "v20.04.9", exitStatus: 1, want: exitCategoryInfrastructure, wantDescription: descriptionUnexpectedError},
+		{name: "no status 3 before 20.05.0", flankVersion: "v20.04.9", exitStatus: 3, want: exitCategoryUnknown},
+		{name: "general failure since 20.05.0", flankVersion: "v20.05.0", exitStatus: 1, want: exitCategoryGeneral, wantDescription: descriptionGeneralFailure},
+		{name: "unexpected error since 20.05.0", flankVersion: "v20.05.0", exitStatus: 3, want: exitCategoryInfrastructure, wantDescription: descriptionUnexpectedError},
+		{
+			name:            "timeout since 20.05.0",
+			flankVersion:    "v21.01.1",
+			exitStatus:      3,
+			flankErr:        &flankError{Type: "FlankTimeoutError", Message: "Canceling flank due to timeout"},
+			want:            exitCategoryTimeout,
+			wantDescription: descriptionUnexpectedError,
+		},
+		{
+			name:            "canceled matrix before 20.05.0",
+			flankVersion:    "v8.1.0",
+			exitStatus:      1,
+			flankErr:        &flankError{Type: "MatrixCanceledError", Message: "matrix-1 was canceled"},
+			want:            exitCategoryCancelled,
+			wantDescription: descriptionUnexpectedError,
+		},
+		{name: "canceled by the user", flankVersion: "v20.08.0", exitStatus: 19, want: exitCategoryCancelled, wantDescription: descriptionCanceled},
+		{name: "infrastructure", flankVersion: "v20.08.0", exitStatus: 20, want: exitCategoryInfrastructure, wantDescription: "A test infrastructure error occurred."},
+		{name: "unknown", flankVersion: "v20.08.0", exitStatus: 42, want: exitCategoryUnknown},
+		{
+			name:            "unknown refined by flank error",
+			flankVersion:    "v8.1.0",
+			exitStatus:      3,
+			flankErr:        &flankError{Type: "FlankTimeoutError", Message: "Test run exceeded the 15m timeout"},
+			want:            exitCategoryTimeout,
+			wantDescription: "Test run exceeded the 15m timeout",
+		},
+		{
+			name:            "general failure refined by flank error",
+			flankVersion:    "v20.08.0",
+			exitStatus:      1,
+			flankErr:        &flankError{Type: "FlankConfigurationError", Message: "Unknown device model"},
+			want:            exitCategoryConfig,
+			wantDescription: "A general failure occurred. Possible causes include: a filename that does not exist or an HTTP/network error.",
+		},
+		{
+			name:            "known category is kept",
+			flankVersion:    "v20.08.0",
+			exitStatus:      10,
+			flankErr:        &flankError{Type: "FlankConfigurationError", Message: "Unknown device model"},
+			want:            exitCategoryTestFailure,
+			wantDescription: "At least one test failed or was inconclusive.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyExitStatus(tt.flankVersion, tt.exitStatus, tt.flankErr)
+			if got.Category != tt.want || got.Status != tt.exitStatus {
+				t.Errorf("classifyExitStatus() = %+v, want category %s", got, tt.want)
+			}
+			if got.Description != tt.wantDescription {
+				t.Errorf("classifyExitStatus() description = %q, want %q", got.Description, tt.wantDescription)
+			}
+		})
+	}
+}
+
+func Test_parseFlankError(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   *flankError
+	}{
+		{name: "no error", output: "[2020-08-10 10:00:00] Uploading app.apk\n[2020-08-10 10:00:01] Done\n", want: nil},
+		{
+			name:   "last error wins",
+			output: "[2020-08-10 10:00:00] FTLError: quota exceeded\n[2020-08-10 10:00:01] \x1b[31mFlankTimeoutError: Test run exceeded the 15m timeout\x1b[0m\n",
+			want:   &flankError{Type: "FlankTimeoutError", Message: "Test run exceeded the 15m timeout"},
+		},
+		{
+			name:   "uncaught flank error",
+			output: "Exception in thread \"main\" ftl.run.exception.FlankGeneralError: app.apk not found\n",
+			want:   &flankError{Type: "FlankGeneralError", Message: "app.apk not found"},
+		},
+		{
+			name: "errors of the tests",
+			output: "[2020-08-10 10:00:00] java.lang.AssertionError: expected:<1> but was:<2>\n" +
+				"[2020-08-10 10:00:01] E/TestRunner: java.io.FileNotFoundException: app.apk\n" +
+				"[2020-08-10 10:00:02] testLogin failed with FTLError: mocked\n",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFlankError(strings.NewReader(tt.output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFlankError() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseExitCodePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []string
+		want    []exitPolicyRule
+		wantErr bool
+	}{
+		{name: "empty", rules: nil, want: nil},
+		{
+			name:  "valid rules",
+			rules: []string{"15:warning", " infrastructure : warning ", "flaky_passed:success", ""},
+			want: []exitPolicyRule{
+				{Condition: "15", Action: exitActionWarning},
+				{Condition: "infrastructure", Action: exitActionWarning},
+				{Condition: "flaky_passed", Action: exitActionSuccess},
+			},
+		},
+		{name: "missing action", rules: []string{"15"}, wantErr: true},
+		{name: "unknown condition", rules: []string{"slow:failure"}, wantErr: true},
+		{name: "unknown action", rules: []string{"15:ignore"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExitCodePolicy(tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExitCodePolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseExitCodePolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyExitCodePolicy(t *testing.T) {
+	policy, err := parseExitCodePolicy([]string{"flaky_passed:success", "inconclusive:warning", "20:warning", "config:warning", "no_tests:failure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		exitStatus   int
+		summary      *testSummary
+		wantAction   exitAction
+		wantExitCode int
+	}{
+		{name: "passed", exitStatus: 0, summary: &testSummary{Total: 1, Passed: 1}, wantAction: exitActionSuccess, wantExitCode: 0},
+		{name: "failed", exitStatus: 10, summary: &testSummary{Total: 2, Passed: 1, Failed: 1}, wantAction: exitActionFailure, wantExitCode: 10},
+		{name: "flaky passed", exitStatus: 10, summary: &testSummary{Total: 2, Passed: 2, Flaky: 1}, wantAction: exitActionSuccess, wantExitCode: 0},
+		{name: "inconclusive by category", exitStatus: 15, summary: &testSummary{Total: 1, Passed: 1}, wantAction: exitActionWarning, wantExitCode: 0},
+		{name: "infrastructure by status", exitStatus: 20, summary: &testSummary{Total: 1, Passed: 1}, wantAction: exitActionWarning, wantExitCode: 0},
+		{name: "no tests fails a passing run", exitStatus: 0, summary: nil, wantAction: exitActionFailure, wantExitCode: 1},
+		{name: "config error without test results", exitStatus: 2, summary: nil, wantAction: exitActionWarning, wantExitCode: 0},
+		{name: "general failure without test results", exitStatus: 1, summary: nil, wantAction: exitActionFailure, wantExitCode: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAction, _ := applyExitCodePolicy(policy, classifyExitStatus("v20.08.0", tt.exitStatus, nil), tt.summary)
+			if gotAction != tt.wantAction {
+				t.Errorf("applyExitCodePolicy() = %s, want %s", gotAction, tt.wantAction)
+			}
+			if got := stepExitCode(gotAction, tt.exitStatus); got != tt.wantExitCode {
+				t.Errorf("stepExitCode() = %d, want %d", got, tt.wantExitCode)
+			}
+		})
+	}
+}
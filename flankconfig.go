@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultLocalResultDir = "results"
+
+// flankConfig contains the fields of the flank config yml the step relies on
+type flankConfig struct {
+	Gcloud struct {
+		App                  string                   `yaml:"app"`
+		Test                 string                   `yaml:"test"`
+		ResultsDir           string                   `yaml:"results-dir"`
+		Device               []map[string]interface{} `yaml:"device"`
+		Timeout              string                   `yaml:"timeout"`
+		NumFlakyTestAttempts int                      `yaml:"num-flaky-test-attempts"`
+		XCTestRunFile        string                   `yaml:"xctestrun-file"`
+	} `yaml:"gcloud"`
+	Flank struct {
+		LocalResultDir        string   `yaml:"local-result-dir"`
+		DefaultTestTime       float64  `yaml:"default-test-time"`
+		DefaultClassTestTime  float64  `yaml:"default-class-test-time"`
+		MaxTestShards         int      `yaml:"max-test-shards"`
+		NumTestRuns           int      `yaml:"num-test-runs"`
+		OnlyTestConfiguration string   `yaml:"only-test-configuration"`
+		SkipTestConfiguration string   `yaml:"skip-test-configuration"`
+		TestTargets           []string `yaml:"test-targets"`
+		AdditionalAppTestApks []struct {
+			App  string `yaml:"app"`
+			Test string `yaml:"test"`
+		} `yaml:"additional-app-test-apks"`
+	} `yaml:"flank"`
+}
+
+func readFlankConfig(configYMLPath string) (flankConfig, error) {
+	var cfg flankConfig
+
+	ymlBytes, err := fileutil.ReadBytesFromFile(configYMLPath)
+	if err != nil {
+		return flankConfig{}, err
+	}
+
+	if err := yaml.Unmarshal(ymlBytes, &cfg); err != nil {
+		return flankConfig{}, err
+	}
+	return cfg, nil
+}
+
+// returns the values of every --name=value or --name value flag
+func flagValues(args []string, name string) []string {
+	var values []string
+	for i, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, name+"="):
+			values = append(values, strings.TrimPrefix(arg, name+"="))
+		case arg == name && i+1 < len(args):
+			values = append(values, args[i+1])
+		}
+	}
+	return values
+}
+
+// returns the value of a --name=value or --name value flag, the last occurrence wins as in flank
+func flagValue(args []string, name string) string {
+	values := flagValues(args, name)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// returns the local-result-dir and results-dir used by flank, command flags override the config values
+func resultDirSettings(cfg flankConfig, commandFlags []string) (localResultDir string, resultsDir string) {
+	localResultDir = cfg.Flank.LocalResultDir
+	if value := flagValue(commandFlags, "--local-result-dir"); value != "" {
+		localResultDir = value
+	}
+	if localResultDir == "" {
+		localResultDir = defaultLocalResultDir
+	}
+
+	resultsDir = cfg.Gcloud.ResultsDir
+	if value := flagValue(commandFlags, "--results-dir"); value != "" {
+		resultsDir = value
+	}
+	return localResultDir, resultsDir
+}
+
+// returns the names of the dirs inside the local result dir, a missing local result dir has no dirs
+func listResultDirs(localResultDir string) (map[string]bool, error) {
+	fInfs, err := ioutil.ReadDir(localResultDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]bool{}, nil
+		}
+		return nil, err
+	}
+
+	dirs := map[string]bool{}
+	for _, fInf := range fInfs {
+		if fInf.IsDir() {
+			dirs[fInf.Name()] = true
+		}
+	}
+	return dirs, nil
+}
+
+// returns true if the dir or one of the files flank writes into it was modified since the given time
+func modifiedSince(dir string, t time.Time) (bool, error) {
+	for _, pth := range []string{dir, filepath.Join(dir, matrixIDsFileName), filepath.Join(dir, junitReportFileName)} {
+		fInf, err := os.Stat(pth)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		if !fInf.ModTime().Before(t.Truncate(time.Second)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// selects the result dir created by the current flank run:
+// if results-dir is configured flank uses that dir, it is selected if the run created or updated it,
+// otherwise the run creates a new (timestamp named) dir, which is found by comparing the dirs before and after the run
+func selectResultDir(localResultDir, resultsDir string, dirsBefore map[string]bool, startTime time.Time) (string, error) {
+	if resultsDir != "" {
+		pth := filepath.Join(localResultDir, resultsDir)
+		if exist, err := pathutil.IsDirExists(pth); err != nil {
+			return "", err
+		} else if !exist {
+			return "", fmt.Errorf("configured result dir (%s) does not exist", pth)
+		}
+		// the dir may be left from a previous run (eg.: a cached workspace)
+		if dirsBefore[resultsDir] {
+			if modified, err := modifiedSince(pth, startTime); err != nil {
+				return "", err
+			} else if !modified {
+				return "", fmt.Errorf("configured result dir (%s) was not updated by this run", pth)
+			}
+		}
+		return pth, nil
+	}
+
+	dirsAfter, err := listResultDirs(localResultDir)
+	if err != nil {
+		return "", err
+	}
+
+	var created []string
+	for dir := range dirsAfter {
+		if !dirsBefore[dir] {
+			created = append(created, dir)
+		}
+	}
+
+	if len(created) > 1 {
+		// other processes may have created dirs meanwhile, keep the ones modified during the run
+		var modified []string
+		for _, dir := range created {
+			fInf, err := os.Stat(filepath.Join(localResultDir, dir))
+			if err != nil {
+				return "", err
+			}
+			if !fInf.ModTime().Before(startTime.Truncate(time.Second)) {
+				modified = append(modified, dir)
+			}
+		}
+		created = modified
+	}
+
+	switch len(created) {
+	case 0:
+		return "", fmt.Errorf("flank did not create a result dir in %s", localResultDir)
+	case 1:
+		return filepath.Join(localResultDir, created[0]), nil
+	default:
+		return "", fmt.Errorf("multiple result dirs were created in %s during the run: %s", localResultDir, strings.Join(created, ", "))
+	}
+}
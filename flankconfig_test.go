@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_resultDirSettings(t *testing.T) {
+	var configured flankConfig
+	configured.Flank.LocalResultDir = "out"
+	configured.Gcloud.ResultsDir = "run-1"
+
+	tests := []struct {
+		name               string
+		cfg                flankConfig
+		commandFlags       []string
+		wantLocalResultDir string
+		wantResultsDir     string
+	}{
+		{name: "defaults", wantLocalResultDir: "results", wantResultsDir: ""},
+		{name: "config", cfg: configured, wantLocalResultDir: "out", wantResultsDir: "run-1"},
+		{name: "flags override config", cfg: configured, commandFlags: []string{"--local-result-dir=flag-out", "--results-dir", "run-2"}, wantLocalResultDir: "flag-out", wantResultsDir: "run-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotLocalResultDir, gotResultsDir := resultDirSettings(tt.cfg, tt.commandFlags)
+			if gotLocalResultDir != tt.wantLocalResultDir || gotResultsDir != tt.wantResultsDir {
+				t.Errorf("resultDirSettings() = %s, %s, want %s, %s", gotLocalResultDir, gotResultsDir, tt.wantLocalResultDir, tt.wantResultsDir)
+			}
+		})
+	}
+}
+
+func Test_selectResultDir(t *testing.T) {
+	localResultDir, err := pathutil.NormalizedOSTempDirPath("test-local-result-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createDummyFiles(localResultDir, []string{"stale-run/JUnitReport.xml", "fixed-run/JUnitReport.xml", "untouched-run/JUnitReport.xml"}); err != nil {
+		t.Fatal(err)
+	}
+	// the dirs of the previous runs
+	for _, pth := range []string{"untouched-run", "untouched-run/JUnitReport.xml", "fixed-run"} {
+		if err := os.Chtimes(filepath.Join(localResultDir, pth), time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a stale dir touched after the run finished must not be selected
+	dirsBefore, err := listResultDirs(localResultDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startTime := time.Now()
+	if err := createDummyFiles(localResultDir, []string{"2019-04-01_10-20-30/JUnitReport.xml"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(localResultDir, "stale-run"), time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// the run rewrote the report of the configured dir
+	if err := os.Chtimes(filepath.Join(localResultDir, "fixed-run", "JUnitReport.xml"), time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		resultsDir string
+		dirsBefore map[string]bool
+		want       string
+		wantErr    bool
+	}{
+		{name: "new dir", dirsBefore: dirsBefore, want: filepath.Join(localResultDir, "2019-04-01_10-20-30")},
+		{name: "configured dir", resultsDir: "fixed-run", dirsBefore: dirsBefore, want: filepath.Join(localResultDir, "fixed-run")},
+		{name: "configured dir missing", resultsDir: "missing", dirsBefore: dirsBefore, wantErr: true},
+		{name: "configured dir not touched by the run", resultsDir: "untouched-run", dirsBefore: dirsBefore, wantErr: true},
+		{name: "configured dir created by the run", resultsDir: "2019-04-01_10-20-30", dirsBefore: dirsBefore, want: filepath.Join(localResultDir, "2019-04-01_10-20-30")},
+		{name: "no new dir", dirsBefore: map[string]bool{"stale-run": true, "fixed-run": true, "untouched-run": true, "2019-04-01_10-20-30": true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectResultDir(localResultDir, tt.resultsDir, tt.dirsBefore, startTime)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectResultDir() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("selectResultDir() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
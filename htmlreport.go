@@ -0,0 +1,233 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const htmlReportFileName = "flank-report.html"
+
+// reportGroup is a set of test cases with their summary, eg. the tests ran on a device or in a shard
+type reportGroup struct {
+	Name      string
+	Summary   testSummary
+	TestCases []reportTestCase
+}
+
+type reportTestCase struct {
+	Group     string
+	ClassName string
+	Name      string
+	Status    string
+	Time      float64
+	Flaky     bool
+	Message   string
+	Details   string
+}
+
+type htmlReport struct {
+	Title    string
+	Summary  testSummary
+	Devices  []reportGroup
+	Shards   []reportGroup
+	Failures []reportTestCase
+	Matrices []matrixResult
+}
+
+func (tc junitTestCase) status() string {
+	switch {
+	case tc.failed():
+		return "failed"
+	case tc.errored():
+		return "error"
+	case tc.skipped():
+		return "skipped"
+	case tc.Flaky:
+		return "flaky"
+	default:
+		return "passed"
+	}
+}
+
+func (tc junitTestCase) firstFailure() (message string, details string) {
+	for _, messages := range [][]junitMessage{tc.Failures, tc.Errors} {
+		if len(messages) > 0 {
+			return messages[0].Message, strings.TrimSpace(messages[0].Content)
+		}
+	}
+	return "", ""
+}
+
+func newReportTestCase(tc junitTestCase) reportTestCase {
+	message, details := tc.firstFailure()
+	if message == "" {
+		message = strings.SplitN(details, "\n", 2)[0]
+	}
+	return reportTestCase{
+		ClassName: tc.ClassName,
+		Name:      tc.Name,
+		Status:    tc.status(),
+		Time:      parseJUnitTime(tc.Time),
+		Flaky:     tc.Flaky,
+		Message:   message,
+		Details:   details,
+	}
+}
+
+func newReportGroup(name string, suites ...junitTestSuite) reportGroup {
+	group := reportGroup{Name: name, Summary: summarizeTests(junitTestSuites{Suites: suites})}
+	for _, suite := range suites {
+		for _, tc := range suite.TestCases {
+			testCase := newReportTestCase(tc)
+			testCase.Group = name
+			group.TestCases = append(group.TestCases, testCase)
+		}
+	}
+	return group
+}
+
+// groups the suites by their device, the suites without a known device (eg. the shards of the legacy junit mode)
+// are left out
+func deviceGroups(suites junitTestSuites) []reportGroup {
+	var names []string
+	byDevice := map[string][]junitTestSuite{}
+	for _, suite := range suites.Suites {
+		device := suite.property(junitPropertyDevice)
+		if device == "" {
+			continue
+		}
+		if _, ok := byDevice[device]; !ok {
+			names = append(names, device)
+		}
+		byDevice[device] = append(byDevice[device], suite)
+	}
+
+	var groups []reportGroup
+	for _, name := range names {
+		groups = append(groups, newReportGroup(name, byDevice[name]...))
+	}
+	return groups
+}
+
+// returns the test cases of every suite, labelled with their device or their shard if the device is not known
+func reportTestCases(suites junitTestSuites) []reportTestCase {
+	var testCases []reportTestCase
+	for _, suite := range suites.Suites {
+		label := suite.property(junitPropertyDevice)
+		if label == "" {
+			label = suite.property(junitPropertyShard)
+		}
+		if label == "" {
+			label = suite.Name
+		}
+		testCases = append(testCases, newReportGroup(label, suite).TestCases...)
+	}
+	return testCases
+}
+
+// groups the canonical report's suites by device and by their shard, suites without a known device or shard are left out of the groups
+func newHTMLReport(title string, suites junitTestSuites, matrices []matrixResult) htmlReport {
+	report := htmlReport{Title: title, Summary: summarizeTests(suites), Matrices: matrices}
+	report.Devices = deviceGroups(suites)
+	for _, tc := range reportTestCases(suites) {
+		if tc.Status == "failed" || tc.Status == "error" {
+			report.Failures = append(report.Failures, tc)
+		}
+	}
+
+	byShard := map[string][]junitTestSuite{}
+	for _, suite := range suites.Suites {
+		if shard := suite.property(junitPropertyShard); shard != "" {
+			byShard[shard] = append(byShard[shard], suite)
+		}
+	}
+	var shardNames []string
+	for name := range byShard {
+		shardNames = append(shardNames, name)
+	}
+	sort.Strings(shardNames)
+	for _, name := range shardNames {
+		report.Shards = append(report.Shards, newReportGroup(name, byShard[name]...))
+	}
+
+	return report
+}
+
+func formatSeconds(t float64) string {
+	return strconv.FormatFloat(t, 'f', 3, 64) + "s"
+}
+
+func writeHTMLReport(pth string, report htmlReport) (err error) {
+	f, err := os.Create(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	return htmlReportTemplate.Execute(f, report)
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"seconds": formatSeconds,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+pre { margin: 0; white-space: pre-wrap; font-size: 12px; }
+.passed { color: #2e7d32; } .failed, .error { color: #c62828; } .skipped { color: #757575; } .flaky { color: #ef6c00; }
+details { margin-bottom: 1em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>Total</th><th>Passed</th><th>Failed</th><th>Errors</th><th>Skipped</th><th>Flaky</th><th>Time</th></tr>
+<tr><td>{{.Summary.Total}}</td><td class="passed">{{.Summary.Passed}}</td><td class="failed">{{.Summary.Failed}}</td><td class="error">{{.Summary.Errors}}</td><td class="skipped">{{.Summary.Skipped}}</td><td class="flaky">{{.Summary.Flaky}}</td><td>{{seconds .Summary.Time}}</td></tr>
+</table>
+{{if .Matrices}}
+<h2>Test matrices</h2>
+<table>
+<tr><th>Matrix</th><th>Outcome</th><th>Links</th></tr>
+{{range .Matrices}}<tr><td>{{.ID}}</td><td>{{.Outcome}}</td><td>{{if .ConsoleURL}}<a href="{{.ConsoleURL}}">Firebase console</a>{{end}} {{if .ResultsURL}}<a href="{{.ResultsURL}}">Results</a>{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Failures}}
+<h2>Failures</h2>
+{{range .Failures}}<details>
+<summary class="{{.Status}}">[{{.Group}}] {{.ClassName}}#{{.Name}} ({{seconds .Time}}) {{.Message}}</summary>
+<pre>{{.Details}}</pre>
+</details>
+{{end}}
+{{end}}
+{{define "groups"}}{{range .}}
+<h3>{{.Name}}</h3>
+<p>{{.Summary.Total}} tests, {{.Summary.Failed}} failed, {{.Summary.Errors}} errors, {{.Summary.Skipped}} skipped, {{.Summary.Flaky}} flaky in {{seconds .Summary.Time}}</p>
+<table>
+<tr><th>Test</th><th>Status</th><th>Time</th></tr>
+{{range .TestCases}}<tr><td>{{.ClassName}}#{{.Name}}</td><td class="{{.Status}}">{{.Status}}{{if and .Flaky (ne .Status "flaky")}} (flaky){{end}}</td><td>{{seconds .Time}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+{{if .Devices}}
+<h2>Devices</h2>
+{{template "groups" .Devices}}
+{{end}}
+{{if .Shards}}
+<h2>Shards</h2>
+{{template "groups" .Shards}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_newHTMLReport(t *testing.T) {
+	resultDir, err := pathutil.NormalizedOSTempDirPath("test-html-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pth := writeTestFile(t, resultDir, junitReportFileName, testJUnitReport)
+	suites, err := parseJUnitReport(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suites.Suites[0].Properties = []junitProperty{{Name: junitPropertyDevice, Value: "NexusLowRes-28-en-portrait"}, {Name: junitPropertyShard, Value: "shard_0"}}
+	suites.Suites[1].Properties = []junitProperty{{Name: junitPropertyDevice, Value: "Pixel2-29-en-portrait"}}
+	// legacy junit mode: the device of the shard is not known
+	suites.Suites = append(suites.Suites, junitTestSuite{
+		Name:       "shard_1",
+		Properties: []junitProperty{{Name: junitPropertyShard, Value: "shard_1"}},
+		TestCases:  []junitTestCase{{Name: "testTimeout", ClassName: "com.example.SlowTest", Failures: []junitMessage{{Message: "timed out"}}}},
+	})
+
+	matrices := []matrixResult{{ID: "matrix-1", Outcome: "failure", ConsoleURL: "https://console.firebase.google.com/matrix-1"}}
+	report := newHTMLReport("android - flank", suites, matrices)
+
+	if len(report.Devices) != 2 || report.Devices[0].Name != "NexusLowRes-28-en-portrait" {
+		t.Errorf("unexpected devices: %+v", report.Devices)
+	}
+	if len(report.Shards) != 2 || report.Shards[0].Name != "shard_0" || report.Shards[0].Summary.Total != 5 || report.Shards[1].Name != "shard_1" {
+		t.Errorf("unexpected shards: %+v", report.Shards)
+	}
+	if len(report.Failures) != 3 || report.Failures[0].Message != "java.lang.AssertionError: expected:<1> but was:<2>" || report.Failures[1].Message != "Process crashed." {
+		t.Errorf("unexpected failures: %+v", report.Failures)
+	}
+	if failure := report.Failures[2]; failure.Name != "testTimeout" || failure.Group != "shard_1" {
+		t.Errorf("failure of the legacy shard = %+v, want it labelled with its shard", failure)
+	}
+
+	reportPath := filepath.Join(resultDir, htmlReportFileName)
+	if err := writeHTMLReport(reportPath, report); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<title>android - flank</title>",
+		`<a href="https://console.firebase.google.com/matrix-1">Firebase console</a>`,
+		"expected:&lt;1&gt; but was:&lt;2&gt;",
+		`<td class="flaky">flaky</td>`,
+		"<h2>Shards</h2>",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("report does not contain %s", want)
+		}
+	}
+}
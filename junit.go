@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+const junitReportFileName = "JUnitReport.xml"
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite is a testsuite element, its test counts are only set when the canonical report is written
+type junitTestSuite struct {
+	XMLName    xml.Name        `xml:"testsuite"`
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr,omitempty"`
+	Failures   int             `xml:"failures,attr,omitempty"`
+	Errors     int             `xml:"errors,attr,omitempty"`
+	Skipped    int             `xml:"skipped,attr,omitempty"`
+	Flakes     int             `xml:"flakes,attr,omitempty"`
+	Time       string          `xml:"time,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name       string          `xml:"name,attr"`
+	ClassName  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr,omitempty"`
+	Flaky      bool            `xml:"flaky,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property"`
+	Failures   []junitMessage  `xml:"failure"`
+	Errors     []junitMessage  `xml:"error"`
+	Skipped    *junitMessage   `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Content string `xml:",chardata"`
+}
+
+type testSummary struct {
+	Total   int
+	Passed  int
+	Failed  int
+	Errors  int
+	Skipped int
+	Flaky   int
+	Time    float64
+}
+
+// parses a JUnit xml, the root element can be either testsuites or a single testsuite
+func parseJUnitReport(pth string) (junitTestSuites, error) {
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return junitTestSuites{}, err
+	}
+
+	var suites junitTestSuites
+	if err := xml.Unmarshal(data, &suites); err == nil {
+		return suites, nil
+	}
+
+	var suite junitTestSuite
+	if err := xml.Unmarshal(data, &suite); err != nil {
+		return junitTestSuites{}, fmt.Errorf("failed to parse %s, error: %s", pth, err)
+	}
+	return junitTestSuites{Suites: []junitTestSuite{suite}}, nil
+}
+
+// returns the time attribute in seconds, flank sometimes leaves it empty or uses comma as decimal separator
+func parseJUnitTime(s string) float64 {
+	t, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(s), ",", ".", -1), 64)
+	if err != nil {
+		return 0
+	}
+	return t
+}
+
+func (tc junitTestCase) failed() bool {
+	return !tc.Flaky && len(tc.Failures) > 0
+}
+
+func (tc junitTestCase) errored() bool {
+	return !tc.Flaky && len(tc.Failures) == 0 && len(tc.Errors) > 0
+}
+
+func (tc junitTestCase) skipped() bool {
+	return tc.Skipped != nil && len(tc.Failures) == 0 && len(tc.Errors) == 0
+}
+
+// counts the test cases by their result, flaky tests (failed at first but passed on a rerun) count as passed
+func summarizeTests(suites junitTestSuites) testSummary {
+	var summary testSummary
+	for _, suite := range suites.Suites {
+		var casesTime float64
+		for _, tc := range suite.TestCases {
+			summary.Total++
+			casesTime += parseJUnitTime(tc.Time)
+
+			switch {
+			case tc.failed():
+				summary.Failed++
+			case tc.errored():
+				summary.Errors++
+			case tc.skipped():
+				summary.Skipped++
+			default:
+				summary.Passed++
+			}
+			if tc.Flaky {
+				summary.Flaky++
+			}
+		}
+
+		if suite.Time != "" {
+			summary.Time += parseJUnitTime(suite.Time)
+		} else {
+			summary.Time += casesTime
+		}
+	}
+	return summary
+}
+
+func (s testSummary) outputs() [][2]string {
+	return [][2]string{
+		{"FLANK_TESTS_TOTAL", strconv.Itoa(s.Total)},
+		{"FLANK_TESTS_PASSED", strconv.Itoa(s.Passed)},
+		{"FLANK_TESTS_FAILED", strconv.Itoa(s.Failed)},
+		{"FLANK_TESTS_ERRORS", strconv.Itoa(s.Errors)},
+		{"FLANK_TESTS_SKIPPED", strconv.Itoa(s.Skipped)},
+		{"FLANK_TESTS_FLAKY", strconv.Itoa(s.Flaky)},
+		{"FLANK_TESTS_DURATION", strconv.FormatFloat(s.Time, 'f', 3, 64)},
+	}
+}
+
+func printTestSummary(w io.Writer, s testSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintln(tw, " Total\t Passed\t Failed\t Errors\t Skipped\t Flaky\t Time\t")
+	fmt.Fprintf(tw, " %d\t %d\t %d\t %d\t %d\t %d\t %.3fs\t\n", s.Total, s.Passed, s.Failed, s.Errors, s.Skipped, s.Flaky, s.Time)
+	return tw.Flush()
+}
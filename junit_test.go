@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const testJUnitReport = `<?xml version='1.0' encoding='UTF-8' ?>
+<testsuites>
+  <testsuite name="NexusLowRes-28-en-portrait" tests="5" failures="1" flakes="1" errors="0" skipped="1" time="12.5" timestamp="2019-04-01T10:20:30" hostname="localhost">
+    <testcase name="testPass" classname="com.example.MainTest" time="1.0"/>
+    <testcase name="testFail" classname="com.example.MainTest" time="2.5">
+      <failure>java.lang.AssertionError: expected:&lt;1&gt; but was:&lt;2&gt;
+	at com.example.MainTest.testFail(MainTest.kt:20)</failure>
+    </testcase>
+    <testcase name="testFlaky" classname="com.example.MainTest" time="3.0" flaky="true"/>
+    <testcase name="testSkipped" classname="com.example.MainTest" time="0.0">
+      <skipped/>
+    </testcase>
+    <testcase name="testCrash" classname="com.example.OtherTest" time="">
+      <error message="Process crashed.">Native crash</error>
+    </testcase>
+  </testsuite>
+  <testsuite name="Pixel2-29-en-portrait" time="">
+    <testcase name="testPass" classname="com.example.MainTest" time="1,5"/>
+  </testsuite>
+</testsuites>
+`
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	pth := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(pth, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return pth
+}
+
+func Test_summarizeTests(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    testSummary
+		wantErr bool
+	}{
+		{
+			name:    "testsuites root",
+			content: testJUnitReport,
+			want:    testSummary{Total: 6, Passed: 3, Failed: 1, Errors: 1, Skipped: 1, Flaky: 1, Time: 14},
+		},
+		{
+			name:    "testsuite root",
+			content: `<testsuite name="suite" time="2"><testcase name="a" classname="A" time="2"/></testsuite>`,
+			want:    testSummary{Total: 1, Passed: 1, Time: 2},
+		},
+		{
+			name:    "invalid xml",
+			content: `<testsuites><testsuite>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := writeTestFile(t, tmpDir, junitReportFileName, tt.content)
+
+			suites, err := parseJUnitReport(pth)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJUnitReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := summarizeTests(suites); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("summarizeTests() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_printTestSummary(t *testing.T) {
+	var b bytes.Buffer
+	if err := printTestSummary(&b, testSummary{Total: 6, Passed: 3, Failed: 1, Errors: 1, Skipped: 1, Flaky: 1, Time: 14}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("printTestSummary() printed %d lines, want 2:\n%s", len(lines), b.String())
+	}
+	if !strings.Contains(lines[0], "Failed") || !strings.Contains(lines[1], "14.000s") {
+		t.Errorf("printTestSummary() unexpected output:\n%s", b.String())
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	canonicalJUnitFileName  = "flank-junit.xml"
+	fullJUnitReportFileName = "FullJUnitReport.xml"
+
+	junitPropertyDevice   = "device"
+	junitPropertyShard    = "shard"
+	junitPropertyFlaky    = "flaky"
+	junitPropertyAttempts = "attempts"
+)
+
+var (
+	// device names of flank and Test Lab: {model}-{version}-{locale}-{orientation}, eg.: NexusLowRes-28-en-portrait, iphone8-12.0-en-portrait
+	deviceNamePattern = regexp.MustCompile(`^.+-[0-9][0-9.]*-[a-zA-Z_]+-(?:portrait|landscape)$`)
+	// Test Lab stores the reruns of a device (num-test-runs, flaky test attempts) next to it, eg.: NexusLowRes-28-en-portrait-rerun_1
+	deviceRerunSuffixPattern = regexp.MustCompile(`[-_]rerun_([0-9]+)$`)
+)
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+func (s junitTestSuite) property(name string) string {
+	for _, p := range s.Properties {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// canonicalKey identifies a suite of the canonical report, the executions of the same device and shard are merged
+type canonicalKey struct {
+	Device string
+	Shard  string
+}
+
+// canonicalBuilder collects the suites by device and shard and the attempts of their test cases in the order of appearance
+type canonicalBuilder struct {
+	keys     []canonicalKey
+	times    map[canonicalKey]float64
+	tests    map[canonicalKey][]string
+	attempts map[canonicalKey]map[string][]junitTestCase
+}
+
+func newCanonicalBuilder() *canonicalBuilder {
+	return &canonicalBuilder{
+		times:    map[canonicalKey]float64{},
+		tests:    map[canonicalKey][]string{},
+		attempts: map[canonicalKey]map[string][]junitTestCase{},
+	}
+}
+
+func (b *canonicalBuilder) add(key canonicalKey, suite junitTestSuite) {
+	if _, ok := b.attempts[key]; !ok {
+		b.keys = append(b.keys, key)
+		b.attempts[key] = map[string][]junitTestCase{}
+	}
+	b.times[key] += summarizeTests(junitTestSuites{Suites: []junitTestSuite{suite}}).Time
+
+	for _, tc := range suite.TestCases {
+		name := tc.ClassName + "#" + tc.Name
+		if _, ok := b.attempts[key][name]; !ok {
+			b.tests[key] = append(b.tests[key], name)
+		}
+		b.attempts[key][name] = append(b.attempts[key][name], tc)
+	}
+}
+
+// returns a suite per device and shard, named after the device (or the shard if the device is not known)
+func (b *canonicalBuilder) suites() junitTestSuites {
+	var suites junitTestSuites
+	for _, key := range b.keys {
+		suite := junitTestSuite{Name: key.Device, Time: strconv.FormatFloat(b.times[key], 'f', 3, 64)}
+		if suite.Name == "" {
+			suite.Name = key.Shard
+		}
+		if key.Device != "" {
+			suite.Properties = append(suite.Properties, junitProperty{Name: junitPropertyDevice, Value: key.Device})
+		}
+		if key.Shard != "" {
+			suite.Properties = append(suite.Properties, junitProperty{Name: junitPropertyShard, Value: key.Shard})
+		}
+		for _, name := range b.tests[key] {
+			suite.TestCases = append(suite.TestCases, mergeTestCaseAttempts(b.attempts[key][name]))
+		}
+		suites.Suites = append(suites.Suites, suite)
+	}
+	return suites
+}
+
+// merges the attempts of a test case on the same device and shard: the test is flaky if it passed and failed
+// or flank marked it flaky, the failure of a flaky test is kept for the reports, skipped attempts count only
+// if every attempt was skipped
+func mergeTestCaseAttempts(attempts []junitTestCase) junitTestCase {
+	var passing, failing, executed *junitTestCase
+	flaky := false
+	for i := range attempts {
+		tc := attempts[i]
+		if tc.skipped() {
+			continue
+		}
+		if executed == nil {
+			executed = &attempts[i]
+		}
+		if len(tc.Failures) > 0 || len(tc.Errors) > 0 {
+			if failing == nil {
+				failing = &attempts[i]
+			}
+		} else {
+			passing = &attempts[i]
+		}
+		flaky = flaky || tc.Flaky
+	}
+
+	merged := attempts[0]
+	switch {
+	case passing != nil:
+		merged = *passing
+	case failing != nil:
+		merged = *failing
+	case executed != nil:
+		merged = *executed
+	}
+
+	merged.Properties = nil
+	if flaky || (passing != nil && failing != nil) {
+		merged.Flaky = true
+		if len(merged.Failures) == 0 && len(merged.Errors) == 0 && failing != nil {
+			merged.Failures, merged.Errors = failing.Failures, failing.Errors
+		}
+		merged.Properties = append(merged.Properties, junitProperty{Name: junitPropertyFlaky, Value: "true"})
+	}
+	if len(attempts) > 1 {
+		merged.Properties = append(merged.Properties, junitProperty{Name: junitPropertyAttempts, Value: strconv.Itoa(len(attempts))})
+	}
+	return merged
+}
+
+// returns the canonical suites of a merged report: in the default junit mode flank names the suites after the device,
+// in the legacy mode after the matrix or shard
+func canonicalSuitesOfReport(report junitTestSuites) junitTestSuites {
+	b := newCanonicalBuilder()
+	for _, suite := range report.Suites {
+		key := canonicalKey{Shard: suite.Name}
+		if deviceNamePattern.MatchString(suite.Name) {
+			key = canonicalKey{Device: suite.Name}
+		}
+		b.add(key, suite)
+	}
+	return b.suites()
+}
+
+// returns the rerun index of a device dir, 0 for the first run
+func deviceRerunIndex(device string) int {
+	match := deviceRerunSuffixPattern.FindStringSubmatch(device)
+	if match == nil {
+		return 0
+	}
+	i, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// returns the canonical suites of the per device results, the device and the shard are taken from their dirs
+func canonicalSuitesOfDeviceResults(results []deviceResult) (junitTestSuites, error) {
+	b := newCanonicalBuilder()
+	for _, result := range results {
+		report, err := parseJUnitReport(result.Path)
+		if err != nil {
+			return junitTestSuites{}, err
+		}
+		key := canonicalKey{Device: deviceRerunSuffixPattern.ReplaceAllString(result.Device, ""), Shard: result.Shard}
+		for _, suite := range report.Suites {
+			b.add(key, suite)
+		}
+	}
+	return b.suites(), nil
+}
+
+func testCaseNames(suites junitTestSuites) map[string]bool {
+	names := map[string]bool{}
+	for _, suite := range suites.Suites {
+		for _, tc := range suite.TestCases {
+			names[tc.ClassName+"#"+tc.Name] = true
+		}
+	}
+	return names
+}
+
+// merges the JUnit outputs of the result dir into the canonical report: a suite per device and shard,
+// the attempts of a test deduplicated into a single test case. The per device results are used if they
+// contain every test of the merged report (FullJUnitReport.xml if flank wrote one, JUnitReport.xml otherwise),
+// the merged report is used if flank did not download them all. Returns the paths of the files used.
+func normalizeJUnitResults(resultDir string) (junitTestSuites, []string, error) {
+	var reportPath string
+	for _, name := range []string{fullJUnitReportFileName, junitReportFileName} {
+		pth := filepath.Join(resultDir, name)
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return junitTestSuites{}, nil, err
+		} else if exist {
+			reportPath = pth
+			break
+		}
+	}
+
+	var fromReport junitTestSuites
+	if reportPath != "" {
+		report, err := parseJUnitReport(reportPath)
+		if err != nil {
+			return junitTestSuites{}, nil, err
+		}
+		fromReport = canonicalSuitesOfReport(report)
+	}
+
+	results, err := findDeviceResults(resultDir)
+	if err != nil {
+		return junitTestSuites{}, nil, err
+	}
+	if len(results) == 0 {
+		if reportPath == "" {
+			return junitTestSuites{}, nil, fmt.Errorf("no JUnit report found in %s", resultDir)
+		}
+		return fromReport, []string{reportPath}, nil
+	}
+
+	// the attempts of a test are merged in the order of the runs
+	sort.SliceStable(results, func(i, j int) bool {
+		return deviceRerunIndex(results[i].Device) < deviceRerunIndex(results[j].Device)
+	})
+	fromDevices, err := canonicalSuitesOfDeviceResults(results)
+	if err != nil {
+		return junitTestSuites{}, nil, err
+	}
+	deviceTests := testCaseNames(fromDevices)
+	for name := range testCaseNames(fromReport) {
+		if !deviceTests[name] {
+			return fromReport, []string{reportPath}, nil
+		}
+	}
+
+	var paths []string
+	for _, result := range results {
+		paths = append(paths, result.Path)
+	}
+	return fromDevices, paths, nil
+}
+
+// writes the report with the test counts of the suites
+func writeJUnitReport(pth string, suites junitTestSuites) error {
+	counted := junitTestSuites{}
+	for _, suite := range suites.Suites {
+		summary := summarizeTests(junitTestSuites{Suites: []junitTestSuite{suite}})
+		suite.Tests, suite.Failures, suite.Errors, suite.Skipped, suite.Flakes = summary.Total, summary.Failed, summary.Errors, summary.Skipped, summary.Flaky
+		counted.Suites = append(counted.Suites, suite)
+	}
+
+	data, err := xml.MarshalIndent(counted, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, append([]byte(xml.Header), data...))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_mergeTestCaseAttempts(t *testing.T) {
+	passed := junitTestCase{Name: "a", ClassName: "A", Time: "1.0"}
+	failed := junitTestCase{Name: "a", ClassName: "A", Time: "2.0", Failures: []junitMessage{{Content: "assertion failed"}}}
+	skipped := junitTestCase{Name: "a", ClassName: "A", Skipped: &junitMessage{}}
+
+	tests := []struct {
+		name     string
+		attempts []junitTestCase
+		want     junitTestCase
+	}{
+		{
+			name:     "single attempt",
+			attempts: []junitTestCase{passed},
+			want:     passed,
+		},
+		{
+			name:     "failed then passed",
+			attempts: []junitTestCase{failed, passed},
+			want: junitTestCase{Name: "a", ClassName: "A", Time: "1.0", Flaky: true, Failures: failed.Failures,
+				Properties: []junitProperty{{Name: junitPropertyFlaky, Value: "true"}, {Name: junitPropertyAttempts, Value: "2"}}},
+		},
+		{
+			name:     "failed on every attempt",
+			attempts: []junitTestCase{failed, failed},
+			want: junitTestCase{Name: "a", ClassName: "A", Time: "2.0", Failures: failed.Failures,
+				Properties: []junitProperty{{Name: junitPropertyAttempts, Value: "2"}}},
+		},
+		{
+			name:     "repeated runs",
+			attempts: []junitTestCase{passed, passed, passed},
+			want:     junitTestCase{Name: "a", ClassName: "A", Time: "1.0", Properties: []junitProperty{{Name: junitPropertyAttempts, Value: "3"}}},
+		},
+		{
+			name:     "skipped attempts are ignored",
+			attempts: []junitTestCase{skipped, failed},
+			want: junitTestCase{Name: "a", ClassName: "A", Time: "2.0", Failures: failed.Failures,
+				Properties: []junitProperty{{Name: junitPropertyAttempts, Value: "2"}}},
+		},
+		{
+			name:     "skipped on every attempt",
+			attempts: []junitTestCase{skipped},
+			want:     skipped,
+		},
+		{
+			name:     "flaky by flank",
+			attempts: []junitTestCase{{Name: "a", ClassName: "A", Flaky: true}},
+			want:     junitTestCase{Name: "a", ClassName: "A", Flaky: true, Properties: []junitProperty{{Name: junitPropertyFlaky, Value: "true"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeTestCaseAttempts(tt.attempts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeTestCaseAttempts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_canonicalSuitesOfReport(t *testing.T) {
+	report := junitTestSuites{Suites: []junitTestSuite{
+		{Name: "NexusLowRes-28-en-portrait", Time: "10", TestCases: []junitTestCase{{Name: "a", ClassName: "A"}}},
+		{Name: "NexusLowRes-28-en-portrait", Time: "5", TestCases: []junitTestCase{{Name: "b", ClassName: "A"}}},
+		// legacy junit mode: the suites are named after the matrix
+		{Name: "matrix-1", Time: "3", TestCases: []junitTestCase{{Name: "a", ClassName: "A"}}},
+	}}
+
+	got := canonicalSuitesOfReport(report)
+	want := junitTestSuites{Suites: []junitTestSuite{
+		{
+			Name: "NexusLowRes-28-en-portrait", Time: "15.000",
+			Properties: []junitProperty{{Name: junitPropertyDevice, Value: "NexusLowRes-28-en-portrait"}},
+			TestCases:  []junitTestCase{{Name: "a", ClassName: "A"}, {Name: "b", ClassName: "A"}},
+		},
+		{
+			Name: "matrix-1", Time: "3.000",
+			Properties: []junitProperty{{Name: junitPropertyShard, Value: "matrix-1"}},
+			TestCases:  []junitTestCase{{Name: "a", ClassName: "A"}},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("canonicalSuitesOfReport() = %+v, want %+v", got, want)
+	}
+}
+
+const testDeviceResult = `<?xml version='1.0' encoding='UTF-8' ?>
+<testsuite name="" tests="2" failures="1" time="4.5">
+  <testcase name="testPass" classname="com.example.MainTest" time="1.0"/>
+  <testcase name="testFlaky" classname="com.example.MainTest" time="3.5">
+    <failure>java.lang.AssertionError</failure>
+  </testcase>
+</testsuite>
+`
+
+const testDeviceRerunResult = `<?xml version='1.0' encoding='UTF-8' ?>
+<testsuite name="" tests="1" time="3.0">
+  <testcase name="testFlaky" classname="com.example.MainTest" time="3.0"/>
+</testsuite>
+`
+
+func Test_normalizeJUnitResults(t *testing.T) {
+	resultDir, err := pathutil.NormalizedOSTempDirPath("test-normalize-junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := normalizeJUnitResults(resultDir); err == nil {
+		t.Error("normalizeJUnitResults() of an empty result dir error = nil")
+	}
+
+	reportPath := writeTestFile(t, resultDir, junitReportFileName, `<testsuites>
+  <testsuite name="NexusLowRes-28-en-portrait" time="8">
+    <testcase name="testPass" classname="com.example.MainTest" time="1.0"/>
+    <testcase name="testFlaky" classname="com.example.MainTest" time="3.0" flaky="true"/>
+  </testsuite>
+</testsuites>`)
+
+	suites, sources, err := normalizeJUnitResults(resultDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sources, []string{reportPath}) || len(suites.Suites) != 1 || suites.Suites[0].property(junitPropertyDevice) != "NexusLowRes-28-en-portrait" {
+		t.Errorf("normalizeJUnitResults() without device results = %+v, %v", suites, sources)
+	}
+
+	deviceDir := filepath.Join(resultDir, "shard_0", "NexusLowRes-28-en-portrait")
+	rerunDir := filepath.Join(resultDir, "shard_0", "NexusLowRes-28-en-portrait-rerun_1")
+	if err := createDummyFiles(resultDir, []string{
+		"shard_0/NexusLowRes-28-en-portrait/test_result_1.xml",
+		"shard_0/NexusLowRes-28-en-portrait-rerun_1/test_result_1.xml",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	devicePath := writeTestFile(t, deviceDir, "test_result_1.xml", testDeviceResult)
+	rerunPath := writeTestFile(t, rerunDir, "test_result_1.xml", testDeviceRerunResult)
+
+	suites, sources, err = normalizeJUnitResults(resultDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sources, []string{devicePath, rerunPath}) {
+		t.Errorf("normalizeJUnitResults() sources = %v", sources)
+	}
+	if len(suites.Suites) != 1 {
+		t.Fatalf("normalizeJUnitResults() = %+v, want a single suite", suites)
+	}
+	suite := suites.Suites[0]
+	if suite.Name != "NexusLowRes-28-en-portrait" || suite.property(junitPropertyShard) != "shard_0" || suite.Time != "7.500" {
+		t.Errorf("normalizeJUnitResults() suite = %+v", suite)
+	}
+	if summary := summarizeTests(suites); summary.Total != 2 || summary.Passed != 2 || summary.Flaky != 1 {
+		t.Errorf("summary of the normalized results = %+v", summary)
+	}
+
+	// a test of the merged report is missing from the per device results (eg.: flank did not download them all)
+	writeTestFile(t, resultDir, fullJUnitReportFileName, `<testsuites>
+  <testsuite name="NexusLowRes-28-en-portrait">
+    <testcase name="testPass" classname="com.example.MainTest" time="1.0"/>
+    <testcase name="testOther" classname="com.example.OtherTest" time="1.0"/>
+  </testsuite>
+</testsuites>`)
+	_, sources, err = normalizeJUnitResults(resultDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(resultDir, fullJUnitReportFileName)}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("normalizeJUnitResults() sources = %v, want %v", sources, want)
+	}
+}
+
+func Test_writeJUnitReport(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-write-junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites, err := parseJUnitReport(writeTestFile(t, tmpDir, junitReportFileName, testJUnitReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical := canonicalSuitesOfReport(suites)
+
+	pth := filepath.Join(tmpDir, canonicalJUnitFileName)
+	if err := writeJUnitReport(pth, canonical); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	for _, want := range []string{
+		`<testsuite name="NexusLowRes-28-en-portrait" tests="5" failures="1" errors="1" skipped="1" flakes="1" time="12.500">`,
+		`<property name="device" value="NexusLowRes-28-en-portrait"></property>`,
+		`<testcase name="testFlaky" classname="com.example.MainTest" time="3.0" flaky="true">`,
+		`<property name="flaky" value="true"></property>`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("written report does not contain %s:\n%s", want, content)
+		}
+	}
+
+	written, err := parseJUnitReport(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summarizeTests(written), summarizeTests(suites); got != want {
+		t.Errorf("summary of the written report = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const logTimestampLayout = "2006-01-02 15:04:05"
+
+var ansiEscapePattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// timestampedLog writes whole lines to the underlying writer, every line is prefixed with the time it was received.
+// The output streams write to it through their own logWriter, so their partial lines do not mix.
+type timestampedLog struct {
+	mu        sync.Mutex
+	w         io.Writer
+	stripANSI bool
+	now       func() time.Time
+}
+
+func newTimestampedLog(w io.Writer, stripANSI bool) *timestampedLog {
+	return &timestampedLog{w: w, stripANSI: stripANSI, now: time.Now}
+}
+
+// newWriter returns the writer of an output stream (eg.: stdout or stderr), it must not be shared between streams
+func (l *timestampedLog) newWriter() *logWriter {
+	return &logWriter{log: l}
+}
+
+func (l *timestampedLog) writeLine(line []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stripANSI {
+		line = ansiEscapePattern.ReplaceAll(line, nil)
+	}
+	if _, err := io.WriteString(l.w, "["+l.now().Format(logTimestampLayout)+"] "); err != nil {
+		return err
+	}
+	_, err := l.w.Write(line)
+	return err
+}
+
+// logWriter writes the received output of a stream line by line to the log, it keeps the incomplete line of the stream
+type logWriter struct {
+	log *timestampedLog
+	buf []byte
+}
+
+// Write buffers the incomplete lines, so it always reports the full length of p as written
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx == -1 {
+			break
+		}
+		line := w.buf[:idx+1]
+		w.buf = w.buf[idx+1:]
+		if err := w.log.writeLine(line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out the remaining incomplete line if there is any
+func (w *logWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := append(w.buf, '\n')
+	w.buf = nil
+	return w.log.writeLine(line)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_logWriter(t *testing.T) {
+	fixedTime := time.Date(2019, 4, 1, 10, 20, 30, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		stripANSI bool
+		writes    []string
+		want      string
+	}{
+		{
+			name:   "complete lines",
+			writes: []string{"line 1\nline 2\n"},
+			want:   "[2019-04-01 10:20:30] line 1\n[2019-04-01 10:20:30] line 2\n",
+		},
+		{
+			name:   "line split between writes",
+			writes: []string{"li", "ne 1\nline", " 2"},
+			want:   "[2019-04-01 10:20:30] line 1\n[2019-04-01 10:20:30] line 2\n",
+		},
+		{
+			name:      "strip ansi",
+			stripANSI: true,
+			writes:    []string{"\x1b[31;1mfailed\x1b[0m\n"},
+			want:      "[2019-04-01 10:20:30] failed\n",
+		},
+		{
+			name:   "keep ansi",
+			writes: []string{"\x1b[31;1mfailed\x1b[0m\n"},
+			want:   "[2019-04-01 10:20:30] \x1b[31;1mfailed\x1b[0m\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			l := newTimestampedLog(&out, tt.stripANSI)
+			l.now = func() time.Time { return fixedTime }
+			w := l.newWriter()
+
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := out.String(); got != tt.want {
+				t.Errorf("logWriter output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_logWriter_streams(t *testing.T) {
+	var out bytes.Buffer
+	l := newTimestampedLog(&out, false)
+	l.now = func() time.Time { return time.Date(2019, 4, 1, 10, 20, 30, 0, time.UTC) }
+	stdout, stderr := l.newWriter(), l.newWriter()
+
+	// the partial lines of the streams are kept separately
+	for _, write := range []struct {
+		w *logWriter
+		s string
+	}{
+		{stdout, "Uploading "},
+		{stderr, "WARNING: "},
+		{stdout, "app.apk\n"},
+		{stderr, "deprecated flag\n"},
+		{stdout, "Done"},
+	} {
+		if _, err := write.w.Write([]byte(write.s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, w := range []*logWriter{stdout, stderr} {
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "[2019-04-01 10:20:30] Uploading app.apk\n[2019-04-01 10:20:30] WARNING: deprecated flag\n[2019-04-01 10:20:30] Done\n"
+	if got := out.String(); got != want {
+		t.Errorf("logWriter output = %q, want %q", got, want)
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,7 +19,6 @@ import (
 	"github.com/bitrise-tools/go-steputils/stepconf"
 	"github.com/hashicorp/go-version"
 	"github.com/kballard/go-shellquote"
-	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -27,35 +28,77 @@ const (
 )
 
 type config struct {
-	ServiceAccountJSON stepconf.Secret `env:"google_service_account_json,required"`
+	Mode               string          `env:"mode,opt[run,list_tests]"`
+	ServiceAccountJSON stepconf.Secret `env:"google_service_account_json"`
 	ConfigPath         string          `env:"config_path,file"`
-	Version            string          `env:"version,required"`
+	Version            string          `env:"version"`
 	CommandFlags       string          `env:"command_flags"`
+	StripANSIFromLog   bool            `env:"strip_ansi_from_log,opt[yes,no]"`
+	TestName           string          `env:"test_name"`
+	ExportDeviceTests  bool            `env:"export_device_test_results,opt[yes,no]"`
+	ArtifactInclude    []string        `env:"artifact_include_patterns"`
+	ArtifactExclude    []string        `env:"artifact_exclude_patterns"`
+	ZipArtifacts       bool            `env:"zip_artifacts,opt[yes,no]"`
+	SummaryMaxFailures int             `env:"summary_max_failures"`
+	ExitCodePolicy     []string        `env:"exit_code_policy"`
+	PreviewShards      bool            `env:"preview_shards,opt[yes,no]"`
+	MaxShardsAllowed   int             `env:"max_shards_allowed"`
+	MaxDeviceMinutes   int             `env:"max_device_minutes"`
+	MaxTestExecutions  int             `env:"max_test_executions"`
+	CostHistoryPath    string          `env:"cost_history_path"`
+	TimingCacheDir     string          `env:"timing_cache_dir"`
+	QuarantineFile     string          `env:"quarantine_file"`
+	TestHistoryDir     string          `env:"test_history_dir"`
+	BaselineResults    string          `env:"baseline_results"`
+	NewFailuresOnly    bool            `env:"fail_on_new_failures_only,opt[yes,no]"`
+	DurationCacheDir   string          `env:"duration_cache_dir"`
+	DurationPercent    int             `env:"duration_regression_percent"`
+	DurationSeconds    int             `env:"duration_regression_seconds"`
+	IncludeAnnotations []string        `env:"include_annotations"`
+	ExcludeAnnotations []string        `env:"exclude_annotations"`
+	IncludePackages    []string        `env:"include_packages"`
+	TestSize           string          `env:"test_size,opt[all,small,medium,large]"`
+	TestImpactBaseRef  string          `env:"test_impact_base_ref"`
+	TestImpactMapping  string          `env:"test_impact_mapping_file"`
+	DeployDir          string          `env:"BITRISE_DEPLOY_DIR"`
+	TestResultDir      string          `env:"BITRISE_TEST_RESULT_DIR"`
+	GitBranch          string          `env:"BITRISE_GIT_BRANCH"`
+	GitCommit          string          `env:"BITRISE_GIT_COMMIT"`
+	BuildNumber        string          `env:"BITRISE_BUILD_NUMBER"`
 }
 
-// returns android if there is an app field under gcloud in the config yml
-func detectPlatform(configYMLPath string) (string, error) {
-	var androidApp struct {
-		Gcloud struct {
-			App string `yaml:"app"`
-		} `yaml:"gcloud"`
+// checks the inputs which are only required if flank runs, the list_tests mode does not use them
+func validateRunInputs(cfg config) error {
+	if string(cfg.ServiceAccountJSON) == "" {
+		return fmt.Errorf("google_service_account_json: required variable is not present")
 	}
-
-	ymlBytes, err := fileutil.ReadBytesFromFile(configYMLPath)
-	if err != nil {
-		return "", err
+	if cfg.Version == "" {
+		return fmt.Errorf("version: required variable is not present")
 	}
+	return nil
+}
 
-	if err := yaml.Unmarshal(ymlBytes, &androidApp); err != nil {
+// returns android if there is an app field under gcloud in the config yml
+func detectPlatform(configYMLPath string) (string, error) {
+	cfg, err := readFlankConfig(configYMLPath)
+	if err != nil {
 		return "", err
 	}
 
-	if len(androidApp.Gcloud.App) > 0 {
+	if len(cfg.Gcloud.App) > 0 {
 		return platformAndroid, nil
 	}
 	return platformIos, nil
 }
 
+// returns the config section of the test-targets, flank reads the iOS ones from its own section
+func testTargetsSection(platform string) string {
+	if platform == platformIos {
+		return "flank"
+	}
+	return "gcloud"
+}
+
 // stores string under a temp path and exports the path to the corresponding env
 func storeCredentials(cred string) error {
 	tmpPth, err := pathutil.NormalizedOSTempDirPath("credential")
@@ -115,17 +158,29 @@ func getLatestVersion(repoURL string) (string, error) {
 	return lastVersion, nil
 }
 
+// if input version is latest then it returns the fetched latest release version otherwise
+// returns the given version
+func resolveVersion(repoURL, version string) (string, error) {
+	if version != "latest" {
+		return version, nil
+	}
+
+	version, err := getLatestVersion(repoURL)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return version, nil
+}
+
 // if input version is latest then it returns the fetched latest release version download url otherwise
 // returns the release version download url for the given version
 func getDownloadURLbyVersion(repoURL, version string) (string, error) {
-	if version == "latest" {
-		var err error
-		if version, err = getLatestVersion(repoURL); err != nil {
-			return "", err
-		}
-		if !strings.HasPrefix(version, "v") {
-			version = "v" + version
-		}
+	version, err := resolveVersion(repoURL, version)
+	if err != nil {
+		return "", err
 	}
 	return fmt.Sprintf("%s/releases/download/%s/flank.jar", baseURL, version), nil
 }
@@ -161,74 +216,54 @@ func download(url string) (string, error) {
 	return binPath, fileutil.WriteBytesToFile(binPath, bodyData)
 }
 
-func failf(format string, args ...interface{}) {
-	log.Errorf(format, args...)
-	os.Exit(1)
-}
-
-// lists all dirs inside of ./results dir and selects the latest(by modtime)
-// then all the files in the root will be copied from this dir to the root of the dir under BITRISE_DEPLOY_DIR
-func exportArtifacts(srcDir, destDir string, copiedHandler func(src, dest string)) error {
-	fInfs, err := ioutil.ReadDir(srcDir)
+// copies the file content from src to dst by streaming it, so big files are not loaded into memory
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
 	if err != nil {
 		return err
 	}
-
-	var latestDir string
-	var latestModtime time.Time
-
-	for _, fInf := range fInfs {
-		if !fInf.IsDir() {
-			continue
-		}
-		if !fInf.ModTime().Before(latestModtime) {
-			latestModtime = fInf.ModTime()
-			latestDir = filepath.Join(srcDir, fInf.Name())
+	defer func() {
+		if err := in.Close(); err != nil {
+			log.Warnf("Failed to close file, error: %s", err)
 		}
-	}
+	}()
 
-	fInfs, err = ioutil.ReadDir(latestDir)
+	out, err := os.Create(dst)
 	if err != nil {
 		return err
 	}
-
-	for _, fInf := range fInfs {
-		if fInf.IsDir() {
-			continue
-		}
-
-		srcFile := filepath.Join(latestDir, fInf.Name())
-		destinationFile := filepath.Join(destDir, fInf.Name())
-
-		data, err := ioutil.ReadFile(srcFile)
-		if err != nil {
-			return err
-		}
-		if err := ioutil.WriteFile(destinationFile, data, 0644); err != nil {
-			return err
-		}
-
-		if copiedHandler != nil {
-			copiedHandler(srcFile, destinationFile)
-		}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
 	}
+	return out.Close()
+}
 
+func exportEnvironmentWithEnvman(key, value string) error {
+	cmd := command.New("envman", "add", "--key", key)
+	cmd.SetStdin(strings.NewReader(value))
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("failed to run envman, error: %s, output: %s", err, out)
+	}
 	return nil
 }
 
-func logExitStatus(exitStatus int) {
-	statusCodes := map[int]string{
-		1:  "A general failure occurred. Possible causes include: a filename that does not exist or an HTTP/network error.",
-		2:  "Usually indicates missing or wrong usage of flags, incorrect parameters, errors in config files.",
-		10: "At least one matrix not finished (usually a FTL internal error) or unexpected error occurred.",
-		15: "Firebase Test Lab could not determine if the test matrix passed or failed, because of an unexpected error.",
-		18: "The test environment for this test execution is not supported because of incompatible test dimensions. This error might occur if the selected Android API level is not supported by the selected device type.",
-		19: "The test matrix was canceled by the user.",
-		20: "A test infrastructure error occurred.",
-	}
+func failf(format string, args ...interface{}) {
+	log.Errorf(format, args...)
+	os.Exit(1)
+}
 
-	if value, ok := statusCodes[exitStatus]; ok {
-		log.Warnf("Flank exited with status code `%d`: %s", exitStatus, value)
+func logExitStatus(classification exitClassification) {
+	if classification.Category == exitCategorySuccess {
+		return
+	}
+	if classification.Description != "" {
+		log.Warnf("Flank exited with status code `%d` (%s): %s", classification.Status, classification.Category, classification.Description)
+	} else {
+		log.Warnf("Flank exited with status code `%d` (%s)", classification.Status, classification.Category)
+	}
+	if classification.FlankError != nil {
+		log.Warnf("Last flank error: %s: %s", classification.FlankError.Type, classification.FlankError.Message)
 	}
 }
 
@@ -242,10 +277,69 @@ func main() {
 	stepconf.Print(cfg)
 	fmt.Println()
 
+	exitPolicy, err := parseExitCodePolicy(cfg.ExitCodePolicy)
+	if err != nil {
+		failf("Issue with input: %s", err)
+	}
+
+	if cfg.Mode == modeListTests {
+		log.Infof("Listing tests")
+		platform, err := detectPlatform(cfg.ConfigPath)
+		if err != nil {
+			failf("Failed to detect platform, error: %s", err)
+		}
+		if platform != platformAndroid {
+			failf("The %s mode is only supported for android", modeListTests)
+		}
+
+		flankCfg, err := readFlankConfig(cfg.ConfigPath)
+		if err != nil {
+			failf("Failed to read config, error: %s", err)
+		}
+		apkPaths, remotePaths := testAPKPaths(flankCfg)
+		for _, pth := range remotePaths {
+			log.Warnf("Test APK on GCS can not be inspected, skipping: %s", pth)
+		}
+
+		var lists []testList
+		for _, pth := range apkPaths {
+			list, err := listAPKTests(pth)
+			if err != nil {
+				failf("Failed to list the tests of %s, error: %s", pth, err)
+			}
+			log.Printf("- %s: %d tests in %d classes", pth, list.Total, len(list.Classes))
+			lists = append(lists, list)
+		}
+
+		testListPath := filepath.Join(cfg.DeployDir, testListFileName)
+		if err := writeTestLists(testListPath, lists); err != nil {
+			failf("Failed to write test list, error: %s", err)
+		}
+		log.Printf("- generated: %s", testListPath)
+
+		if err := exportEnvironmentWithEnvman("FLANK_TEST_LIST_PATH", testListPath); err != nil {
+			failf("Failed to export FLANK_TEST_LIST_PATH, error: %s", err)
+		}
+		log.Printf("- exported: FLANK_TEST_LIST_PATH=%s", testListPath)
+		log.Donef("- Done")
+		return
+	}
+
+	if err := validateRunInputs(cfg); err != nil {
+		failf("Issue with input: %s", err)
+	}
+
 	//
 	// tool setup
 	log.Infof("Downloading binary")
-	downloadURL, err := getDownloadURLbyVersion(baseURL, cfg.Version)
+	downloadPhase := startPhase()
+	flankVersion, err := resolveVersion(baseURL, cfg.Version)
+	if err != nil {
+		failf("Failed to resolve version, error: %s", err)
+	}
+	log.Printf("- Version: %s", flankVersion)
+
+	downloadURL, err := getDownloadURLbyVersion(baseURL, flankVersion)
 	if err != nil {
 		failf("Failed to get download URL, error: %s", err)
 	}
@@ -255,6 +349,8 @@ func main() {
 		failf("Failed to download binary, error: %s", err)
 	}
 
+	report := stepReport{FlankVersion: flankVersion}
+	report.Phases.DownloadSeconds = downloadPhase.seconds()
 	log.Donef("- Done")
 	fmt.Println()
 
@@ -271,39 +367,824 @@ func main() {
 		failf("Failed to detect platform, error: %s", err)
 	}
 	log.Printf("- Detected platform: %s", platform)
+	report.Platform = platform
+
+	testName := cfg.TestName
+	if testName == "" {
+		testName = defaultTestName(platform, cfg.ConfigPath)
+	}
 
 	commandFlags, err := shellquote.Split(cfg.CommandFlags)
 	if err != nil {
 		failf("Failed to split command flags, error: %s", err)
 	}
 
+	flankCfg, err := readFlankConfig(cfg.ConfigPath)
+	if err != nil {
+		failf("Failed to read config, error: %s", err)
+	}
+	localResultDir, resultsDir := resultDirSettings(flankCfg, commandFlags)
+	resultDirsBefore, err := listResultDirs(localResultDir)
+	if err != nil {
+		failf("Failed to list result dirs, error: %s", err)
+	}
+
+	effectiveCfg, err := readEffectiveConfig(cfg.ConfigPath)
+	if err != nil {
+		failf("Failed to read config, error: %s", err)
+	}
+
+	if platform == platformAndroid {
+		fmt.Println()
+		log.Infof("Checking APK pairs")
+		apiLevels := deviceAPILevels(flankCfg, commandFlags)
+		log.Printf("- device API levels: %s", strings.Trim(fmt.Sprint(apiLevels), "[]"))
+
+		var problems []string
+		for _, pair := range apkPairs(flankCfg, commandFlags) {
+			pairProblems, skipped, err := inspectAPKPair(pair, apiLevels)
+			if err != nil {
+				failf("Failed to check APK pair, error: %s", err)
+			}
+			if skipped {
+				log.Warnf("APK pair is not available locally, skipping: %s - %s", pair.App, pair.Test)
+				continue
+			}
+			log.Printf("- %s - %s", pair.App, pair.Test)
+			problems = append(problems, pairProblems...)
+		}
+		if len(problems) > 0 {
+			for _, problem := range problems {
+				log.Errorf("- %s", problem)
+			}
+			failf("The app and test APKs do not match, %d problem(s) found", len(problems))
+		}
+		log.Donef("- Done")
+	}
+
+	if platform == platformIos {
+		fmt.Println()
+		log.Infof("Inspecting iOS tests")
+		testZip, xctestrunPath := iosTestPaths(flankCfg, commandFlags)
+		if xctestrunPath == "" || strings.HasPrefix(xctestrunPath, gcsPathPrefix) {
+			log.Warnf("The xctestrun file is not available locally, skipping: %s", xctestrunPath)
+		} else {
+			run, err := readXCTestRun(xctestrunPath)
+			if err != nil {
+				failf("Failed to read xctestrun file, error: %s", err)
+			}
+			log.Printf("- xctestrun: %s (format version %d)", xctestrunPath, run.FormatVersion)
+			if run.TestPlan != "" {
+				log.Printf("- test plan: %s", run.TestPlan)
+			}
+			if len(run.Configurations) > 0 {
+				log.Printf("- test configurations: %s", strings.Join(run.Configurations, ", "))
+			}
+			for _, target := range run.Targets {
+				kind := "unit test bundle"
+				if target.UITest {
+					kind = "UI test bundle"
+				}
+				if target.Configuration != "" {
+					log.Printf("- %s: %s (%s)", target.Name, kind, target.Configuration)
+				} else {
+					log.Printf("- %s: %s", target.Name, kind)
+				}
+			}
+
+			var executables map[string][]byte
+			if testZip == "" || strings.HasPrefix(testZip, gcsPathPrefix) {
+				log.Warnf("The test zip is not available locally, the test bundles are not inspected: %s", testZip)
+			} else {
+				if executables, err = readTestBundleExecutables(testZip, run.Targets); err != nil {
+					failf("Failed to read test zip, error: %s", err)
+				}
+				for _, name := range run.targetNames() {
+					if _, ok := executables[name]; !ok {
+						log.Warnf("Test bundle of %s not found in %s", name, testZip)
+					}
+				}
+			}
+
+			problems := append(validateTestTargets(run, executables, flankCfg.Flank.TestTargets),
+				validateTestConfigurations(run, flankCfg.Flank.OnlyTestConfiguration, flankCfg.Flank.SkipTestConfiguration)...)
+			if len(flankCfg.Flank.TestTargets) > 0 {
+				log.Printf("- test-targets: %d", len(flankCfg.Flank.TestTargets))
+			}
+			if len(problems) > 0 {
+				for _, problem := range problems {
+					log.Errorf("- %s", problem)
+				}
+				failf("The config does not match the test build, %d problem(s) found", len(problems))
+			}
+		}
+		log.Donef("- Done")
+	}
+
+	if filter := newTestFilter(cfg.IncludeAnnotations, cfg.ExcludeAnnotations, cfg.IncludePackages, cfg.TestSize); !filter.empty() {
+		fmt.Println()
+		log.Infof("Test filter")
+		testTargets := filter.androidTestTargets()
+		if platform == platformIos {
+			var unsupported []string
+			testTargets, unsupported = filter.iosTestTargets()
+			for _, input := range unsupported {
+				log.Warnf("The %s input is not supported for iOS, skipping", input)
+			}
+		}
+
+		if len(testTargets) > 0 {
+			section := testTargetsSection(platform)
+			value, _ := effectiveCfg.get(section, "test-targets")
+			merged := appendTestTargets(value, testTargets)
+			effectiveCfg.set(section, "test-targets", merged)
+			for _, target := range merged {
+				log.Printf("- test-targets: %v", target)
+			}
+		}
+		log.Donef("- Done")
+	}
+
+	if cfg.TestImpactBaseRef != "" {
+		fmt.Println()
+		log.Infof("Test impact")
+		var mapping testImpactMapping
+		if cfg.TestImpactMapping != "" {
+			if mapping, err = readTestImpactMapping(cfg.TestImpactMapping); err != nil {
+				failf("Failed to read test impact mapping, error: %s", err)
+			}
+		}
+
+		selection := testSelectionFullSuite
+		changedFiles, err := gitChangedFiles(cfg.TestImpactBaseRef)
+		if err != nil {
+			log.Warnf("Failed to list the changed files, running the full suite, error: %s", err)
+		} else {
+			impact, err := selectImpactedTests(mapping, changedFiles, platform == platformAndroid)
+			if err != nil {
+				failf("Failed to select the impacted tests, error: %s", err)
+			}
+			log.Printf("- %d files changed since %s", len(impact.ChangedFiles), cfg.TestImpactBaseRef)
+			section := testTargetsSection(platform)
+			value, _ := effectiveCfg.get(section, "test-targets")
+			if targets, reason := impactTestTargets(impact, value, platform == platformAndroid); len(targets) == 0 {
+				log.Printf("- running the full suite: %s", reason)
+			} else {
+				effectiveCfg.set(section, "test-targets", appendTestTargets(value, targets))
+				selection = testSelectionImpacted
+				for _, target := range targets {
+					log.Printf("- test-targets: %s", target)
+				}
+			}
+		}
+
+		if err := exportEnvironmentWithEnvman("FLANK_TEST_SELECTION", selection); err != nil {
+			failf("Failed to export FLANK_TEST_SELECTION, error: %s", err)
+		}
+		log.Printf("- exported: FLANK_TEST_SELECTION=%s", selection)
+		log.Donef("- Done")
+	}
+
+	var quarantine []quarantineEntry
+	if cfg.QuarantineFile != "" {
+		fmt.Println()
+		log.Infof("Quarantine")
+		if quarantine, err = readQuarantineFile(cfg.QuarantineFile); err != nil {
+			failf("Failed to read quarantine file, error: %s", err)
+		}
+		log.Printf("- %d quarantined tests, classes or packages: %s", len(quarantine), cfg.QuarantineFile)
+
+		if len(quarantine) > 0 && platform == platformAndroid {
+			testTargets := quarantineTestTargets(quarantine)
+			value, _ := effectiveCfg.get("gcloud", "test-targets")
+			effectiveCfg.set("gcloud", "test-targets", appendTestTargets(value, testTargets))
+			for _, target := range testTargets {
+				log.Printf("- test-targets: %s", target)
+			}
+		} else if len(quarantine) > 0 {
+			_, xctestrunPath := iosTestPaths(flankCfg, commandFlags)
+			if xctestrunPath == "" || strings.HasPrefix(xctestrunPath, gcsPathPrefix) {
+				log.Warnf("The xctestrun file is not available locally, the quarantined tests are not skipped: %s", xctestrunPath)
+			} else {
+				if flagValue(commandFlags, "--xctestrun-file") != "" {
+					log.Warnf("The --xctestrun-file command flag overrides the xctestrun file with the quarantined tests skipped")
+				}
+				quarantineDir, err := pathutil.NormalizedOSTempDirPath("flank-quarantine")
+				if err != nil {
+					failf("Failed to create quarantine dir, error: %s", err)
+				}
+				pth := filepath.Join(quarantineDir, quarantinedXCTestRunFileName)
+				added, err := writeQuarantinedXCTestRun(xctestrunPath, pth, quarantine)
+				if err != nil {
+					failf("Failed to skip the quarantined tests in the xctestrun file, error: %s", err)
+				}
+				effectiveCfg.set("gcloud", "xctestrun-file", pth)
+				log.Printf("- %d skip-testing identifiers added: %s", added, pth)
+			}
+		}
+		log.Donef("- Done")
+	}
+
+	flankArgs := func(configPath string) []string {
+		return append([]string{"-jar", binaryPath, platform, "run", "-c", configPath}, commandFlags...)
+	}
+	times := shardTimeSettings(flankCfg, commandFlags)
+
+	var timingPath string
+	if cfg.TimingCacheDir != "" {
+		if platform != platformAndroid {
+			log.Warnf("Timing cache is only supported for android, skipping")
+		} else {
+			timingPath = timingCachePath(cfg.TimingCacheDir, testName)
+			if times.Timings, err = readTestTimings(timingPath); err != nil {
+				log.Warnf("Failed to read timing cache, error: %s", err)
+			} else if times.Timings == nil {
+				log.Printf("- no cached test timing found, it is created after the run: %s", timingPath)
+			} else {
+				log.Printf("- cached timing of %d tests: %s", len(times.Timings), timingPath)
+			}
+		}
+	}
+
+	// nil if the shards are not dumped
+	var shards []shard
+	if cfg.PreviewShards || cfg.MaxShardsAllowed > 0 || times.Timings != nil {
+		fmt.Println()
+		log.Infof("Previewing shards")
+		shardsPath := shardsFileName(platform)
+		if err := removeShardsFile(shardsPath); err != nil {
+			failf("Failed to remove previous shards file, error: %s", err)
+		}
+
+		dumpConfigPath, err := effectiveCfg.path(cfg.ConfigPath)
+		if err != nil {
+			failf("Failed to write effective config, error: %s", err)
+		}
+		dumpCommand := command.New("java", append(flankArgs(dumpConfigPath), dumpShardsFlag)...).
+			SetStdout(os.Stdout).
+			SetStderr(os.Stderr)
+		log.Donef("$ %s", dumpCommand.PrintableCommandArgs())
+		fmt.Println()
+		if err := dumpCommand.Run(); err != nil {
+			failf("Failed to dump shards, error: %s", err)
+		}
+
+		if shards, err = readShards(shardsPath); err != nil {
+			failf("Failed to read shards, error: %s", err)
+		}
+		fmt.Println()
+		if err := printShards(os.Stdout, shards, times); err != nil {
+			log.Warnf("Failed to print shards, error: %s", err)
+		}
+		log.Printf("- estimated with %.0fs per test and %.0fs per test class", times.TestTime, times.ClassTestTime)
+
+		deployedShardsPath := filepath.Join(cfg.DeployDir, filepath.Base(shardsPath))
+		if err := copyFile(shardsPath, deployedShardsPath); err != nil {
+			failf("Failed to export shards file, error: %s", err)
+		}
+		log.Printf("- copied: %s -> %s", shardsPath, deployedShardsPath)
+		report.ExportedFiles = append(report.ExportedFiles, deployedShardsPath)
+		if err := exportEnvironmentWithEnvman("FLANK_SHARDS_PATH", deployedShardsPath); err != nil {
+			failf("Failed to export FLANK_SHARDS_PATH, error: %s", err)
+		}
+
+		if cfg.MaxShardsAllowed > 0 && len(shards) > cfg.MaxShardsAllowed {
+			failf("Flank would run %d shards, more than the allowed %d (max_shards_allowed)", len(shards), cfg.MaxShardsAllowed)
+		}
+		log.Donef("- Done")
+		fmt.Println()
+	}
+
+	if times.Timings != nil && len(shards) > 1 {
+		log.Infof("Balancing shards by test timing")
+		matrixNames := map[string]bool{}
+		for _, s := range shards {
+			matrixNames[s.Matrix] = true
+		}
+
+		if len(matrixNames) > 1 {
+			log.Warnf("Shards of multiple test matrices can not be balanced, skipping")
+		} else {
+			shards = balanceShards(shards, times)
+			if err := printShards(os.Stdout, shards, times); err != nil {
+				log.Warnf("Failed to print shards, error: %s", err)
+			}
+			effectiveCfg.set("gcloud", testTargetsForShardKey, testTargetsForShard(shards))
+			log.Printf("- %s of the effective config set", testTargetsForShardKey)
+			log.Donef("- Done")
+		}
+		fmt.Println()
+	}
+
+	if cfg.MaxDeviceMinutes > 0 || cfg.MaxTestExecutions > 0 {
+		log.Infof("Checking run size")
+		plan, err := planRun(flankCfg, commandFlags, shards, times)
+		if err != nil {
+			failf("Failed to plan run, error: %s", err)
+		}
+		log.Printf("- devices: %d", plan.Devices)
+		log.Printf("- shards: %d", plan.Shards)
+		log.Printf("- test runs: %d", plan.TestRuns)
+		log.Printf("- flaky test attempts: %d", plan.FlakyAttempts)
+		log.Printf("- test executions: %d", plan.executions())
+		log.Printf("- device minutes: %.0f", plan.deviceMinutes())
+		if !plan.Estimated {
+			log.Printf("- every shard is expected to run until the %s timeout, enable preview_shards for an estimation", plan.ShardTimeout)
+		}
+
+		if err := checkRunPlan(plan, cfg.MaxDeviceMinutes, cfg.MaxTestExecutions); err != nil {
+			failf("Refusing to run over budget, %s", err)
+		}
+		log.Donef("- Done")
+		fmt.Println()
+	}
+
+	configPath, err := effectiveCfg.path(cfg.ConfigPath)
+	if err != nil {
+		failf("Failed to write effective config, error: %s", err)
+	}
+	if configPath != cfg.ConfigPath {
+		log.Printf("- effective config: %s", configPath)
+	}
+	if report.ConfigHash, err = fileSHA256(configPath); err != nil {
+		failf("Failed to hash config, error: %s", err)
+	}
+
+	logDir, err := pathutil.NormalizedOSTempDirPath("flank-log")
+	if err != nil {
+		failf("Failed to create log dir, error: %s", err)
+	}
+	logPath := filepath.Join(logDir, "flank.log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		failf("Failed to create log file, error: %s", err)
+	}
+	flankLog := newTimestampedLog(logFile, cfg.StripANSIFromLog)
+	stdoutLog, stderrLog := flankLog.newWriter(), flankLog.newWriter()
+
 	fmt.Println()
-	command := command.New("java", append([]string{"-jar", binaryPath, platform, "run", "-c", cfg.ConfigPath}, commandFlags...)...).
+	command := command.New("java", flankArgs(configPath)...).
 		SetStdin(os.Stdin).
-		SetStdout(os.Stdout).
-		SetStderr(os.Stderr)
+		SetStdout(io.MultiWriter(os.Stdout, stdoutLog)).
+		SetStderr(io.MultiWriter(os.Stderr, stderrLog))
 
 	log.Donef("$ %s", command.PrintableCommandArgs())
 	fmt.Println()
+	report.Command = redactCommandArgs(command.GetCmd().Args)
 
+	startTime := time.Now()
 	cmdErr := command.Run()
+	report.Phases.RunSeconds = time.Since(startTime).Seconds()
+
+	for _, w := range []*logWriter{stdoutLog, stderrLog} {
+		if err := w.Flush(); err != nil {
+			log.Warnf("Failed to write log file, error: %s", err)
+		}
+	}
+	if err := logFile.Close(); err != nil {
+		log.Warnf("Failed to close log file, error: %s", err)
+	}
+
+	var flankErr *flankError
+	if cmdErr != nil {
+		if flankErr, err = readFlankError(logPath); err != nil {
+			log.Warnf("Failed to read flank error from log, error: %s", err)
+		}
+	}
+
+	exitStatus := classifyExitStatus(flankVersion, timeoutcmd.ExitStatus(cmdErr), flankErr)
+	report.Exit = stepReportExit{Status: exitStatus.Status, Category: exitStatus.Category, Description: exitStatus.Description}
+	if flankErr != nil {
+		report.Exit.FlankError = flankErr.Type + ": " + flankErr.Message
+	}
 
 	fmt.Println()
-	logExitStatus(timeoutcmd.ExitStatus(cmdErr))
+	logExitStatus(exitStatus)
+	// the classification is exported before the results are read, a run which failed early has none
+	for _, output := range [][2]string{
+		{"FLANK_EXIT_STATUS", strconv.Itoa(exitStatus.Status)},
+		{"FLANK_EXIT_CATEGORY", string(exitStatus.Category)},
+	} {
+		if err := exportEnvironmentWithEnvman(output[0], output[1]); err != nil {
+			failf("Failed to export %s, error: %s", output[0], err)
+		}
+		log.Printf("- exported: %s=%s", output[0], output[1])
+	}
+	fmt.Println()
+
+	// the log is deployed before anything depending on the result dir, it is the only output of a run which failed early
+	deployedLogPath := filepath.Join(cfg.DeployDir, "flank.log")
+	if err := copyFile(logPath, deployedLogPath); err != nil {
+		failf("Failed to export log file, error: %s", err)
+	}
+	log.Printf("- copied: %s -> %s", logPath, deployedLogPath)
+	report.ExportedFiles = append(report.ExportedFiles, deployedLogPath)
+
+	if err := exportEnvironmentWithEnvman("FLANK_LOG_PATH", deployedLogPath); err != nil {
+		failf("Failed to export FLANK_LOG_PATH, error: %s", err)
+	}
+	log.Printf("- exported: FLANK_LOG_PATH=%s", deployedLogPath)
+
+	// flank does not create a result dir if it fails before starting the tests (eg.: config or auth errors)
+	resultDir, resultDirErr := selectResultDir(localResultDir, resultsDir, resultDirsBefore, startTime)
+	if resultDirErr != nil {
+		log.Warnf("Failed to find result dir, error: %s", resultDirErr)
+		resultDir = ""
+	} else {
+		log.Printf("- result dir: %s", resultDir)
+	}
 	fmt.Println()
 
 	//
-	// exporting generated artifacts
+	// test results
+	log.Infof("Test results")
+	var runSummary *testSummary
+	var suites junitTestSuites
+	var junitSources []string
+	err = resultDirErr
+	if err == nil {
+		suites, junitSources, err = normalizeJUnitResults(resultDir)
+	}
+	hasTestResults := err == nil
+	var junitPath string
+	if err != nil {
+		log.Warnf("Failed to read test results, error: %s", err)
+	} else {
+		junitDir, err := pathutil.NormalizedOSTempDirPath("flank-junit")
+		if err != nil {
+			failf("Failed to create JUnit dir, error: %s", err)
+		}
+		junitPath = filepath.Join(junitDir, canonicalJUnitFileName)
+		if err := writeJUnitReport(junitPath, suites); err != nil {
+			failf("Failed to write JUnit report, error: %s", err)
+		}
+		for _, pth := range junitSources {
+			log.Printf("- merged: %s", pth)
+		}
+		fmt.Println()
+
+		report.Tests = newStepReportTests(suites)
+		summary := summarizeTests(suites)
+		runSummary = &summary
+		if err := printTestSummary(os.Stdout, summary); err != nil {
+			log.Warnf("Failed to print test summary, error: %s", err)
+		}
+		fmt.Println()
 
+		for _, output := range summary.outputs() {
+			if err := exportEnvironmentWithEnvman(output[0], output[1]); err != nil {
+				failf("Failed to export %s, error: %s", output[0], err)
+			}
+			log.Printf("- exported: %s=%s", output[0], output[1])
+		}
+		log.Donef("- Done")
+	}
+	fmt.Println()
+
+	var quarantined *quarantineReport
+	if hasTestResults && len(quarantine) > 0 {
+		log.Infof("Quarantined tests")
+		results, rest := splitQuarantined(suites, quarantine)
+		restSummary := summarizeTests(rest)
+		runSummary = &restSummary
+		quarantined = &results
+		report.Quarantined = quarantined
+
+		log.Printf("- %d quarantined tests ran, %d passed, %d failed", len(results.Passed)+len(results.Failed), len(results.Passed), len(results.Failed))
+		for _, name := range results.Failed {
+			log.Printf("- failed: %s", name)
+		}
+		if err := exportEnvironmentWithEnvman("FLANK_TESTS_QUARANTINED_FAILED", strconv.Itoa(len(results.Failed))); err != nil {
+			failf("Failed to export FLANK_TESTS_QUARANTINED_FAILED, error: %s", err)
+		}
+		log.Printf("- exported: FLANK_TESTS_QUARANTINED_FAILED=%d", len(results.Failed))
+		log.Donef("- Done")
+		fmt.Println()
+	}
+
+	var history *historyReport
+	if hasTestResults && cfg.TestHistoryDir != "" {
+		log.Infof("Test history")
+		historyPath := testHistoryPath(cfg.TestHistoryDir, testName)
+		testHist, err := readTestHistory(historyPath)
+		if err != nil {
+			log.Warnf("Failed to read test history, it is recreated, error: %s", err)
+		}
+		if testHist == nil {
+			testHist = &testHistory{}
+		}
+
+		historyResults := testHist.update(cfg.GitBranch, collectTestRunResults(suites))
+		history = &historyResults
+		report.History = history
+		log.Printf("- branch: %s", history.Branch)
+		for _, name := range history.NewlyFailing {
+			log.Printf("- newly failing: %s", name)
+		}
+		for _, name := range history.NewlyFlaky {
+			log.Printf("- newly flaky: %s", name)
+		}
+
+		var topFlaky []string
+		for _, test := range history.TopFlaky {
+			topFlaky = append(topFlaky, test.String())
+			log.Printf("- top flaky: %s", test)
+		}
+		if err := exportEnvironmentWithEnvman("FLANK_TOP_FLAKY_TESTS", strings.Join(topFlaky, "\n")); err != nil {
+			failf("Failed to export FLANK_TOP_FLAKY_TESTS, error: %s", err)
+		}
+		log.Printf("- exported: FLANK_TOP_FLAKY_TESTS")
+
+		if err := writeTestHistory(historyPath, testHist); err != nil {
+			log.Warnf("Failed to update test history, error: %s", err)
+		} else {
+			log.Printf("- updated: %s", historyPath)
+		}
+		log.Donef("- Done")
+		fmt.Println()
+	}
+
+	var baseline *baselineDiff
+	if hasTestResults && cfg.BaselineResults != "" {
+		log.Infof("Baseline comparison")
+		baselineResults, partial, err := readBaselineResults(cfg.BaselineResults)
+		if err != nil {
+			log.Warnf("Failed to read baseline results, error: %s", err)
+		} else {
+			diff := diffBaseline(baselineResults, partial, collectTestRunResults(suites))
+			baseline = &diff
+			report.Baseline = baseline
+			if partial {
+				log.Warnf("The baseline has only the failed tests, the added, removed and slower tests are not known")
+			}
+
+			for _, group := range []struct {
+				title string
+				names []string
+			}{
+				{"new failure", diff.NewFailures},
+				{"fixed", diff.Fixed},
+				{"still failing", diff.StillFailing},
+				{"added", diff.Added},
+				{"removed", diff.Removed},
+			} {
+				for _, name := range group.names {
+					log.Printf("- %s: %s", group.title, name)
+				}
+			}
+			for _, test := range diff.Slower {
+				log.Printf("- slower: %s", test)
+			}
+			log.Printf("- %d new failures, %d fixed, %d still failing, %d added, %d removed, %d slower",
+				len(diff.NewFailures), len(diff.Fixed), len(diff.StillFailing), len(diff.Added), len(diff.Removed), len(diff.Slower))
+
+			if err := exportEnvironmentWithEnvman("FLANK_NEW_FAILURES", strings.Join(diff.NewFailures, "\n")); err != nil {
+				failf("Failed to export FLANK_NEW_FAILURES, error: %s", err)
+			}
+			log.Printf("- exported: FLANK_NEW_FAILURES")
+			log.Donef("- Done")
+		}
+		fmt.Println()
+	}
+
+	var durations *durationReport
+	if hasTestResults {
+		log.Infof("Test durations")
+		shardDurations := collectShardDurations(suites)
+		durations = &durationReport{RunSeconds: report.Phases.RunSeconds, TestLabSeconds: longestDuration(shardDurations), Shards: len(shardDurations)}
+		report.Durations = durations
+		log.Printf("- flank run: %s, Test Lab execution: %s (longest of %d shards)", formatSeconds(durations.RunSeconds), formatSeconds(durations.TestLabSeconds), durations.Shards)
+
+		if cfg.DurationCacheDir != "" {
+			cachePath := durationCachePath(cfg.DurationCacheDir, testName)
+			cache, err := readDurationCache(cachePath)
+			if err != nil {
+				log.Warnf("Failed to read duration cache, it is recreated, error: %s", err)
+			}
+			if cache == nil {
+				cache = &durationCache{}
+			}
+
+			thresholds := durationThresholds{Percent: cfg.DurationPercent, Seconds: cfg.DurationSeconds}
+			durations.RegressedTests, durations.RegressedShards = cache.update(collectTestDurations(suites), shardDurations, thresholds)
+			var regressed []string
+			for _, test := range durations.RegressedTests {
+				regressed = append(regressed, test.String())
+				log.Printf("- slower test: %s", test)
+			}
+			for _, s := range durations.RegressedShards {
+				log.Printf("- slower shard: %s", s)
+			}
+			log.Printf("- %d tests and %d shards are slower than their baseline", len(durations.RegressedTests), len(durations.RegressedShards))
+
+			if err := exportEnvironmentWithEnvman("FLANK_DURATION_REGRESSIONS", strings.Join(regressed, "\n")); err != nil {
+				failf("Failed to export FLANK_DURATION_REGRESSIONS, error: %s", err)
+			}
+			log.Printf("- exported: FLANK_DURATION_REGRESSIONS")
+
+			if err := writeDurationCache(cachePath, cache); err != nil {
+				log.Warnf("Failed to update duration cache, error: %s", err)
+			} else {
+				log.Printf("- updated: %s", cachePath)
+			}
+		}
+		log.Donef("- Done")
+		fmt.Println()
+	}
+
+	if timingPath != "" {
+		log.Infof("Timing cache")
+		if !hasTestResults || exitStatus.Category != exitCategorySuccess {
+			log.Printf("- the run was not successful, the timing cache is not updated")
+		} else if err := writeTestTimings(timingPath, mergeTestTimings(times.Timings, collectTestTimings(suites))); err != nil {
+			log.Warnf("Failed to update timing cache, error: %s", err)
+		} else {
+			log.Printf("- updated: %s", timingPath)
+			log.Donef("- Done")
+		}
+		fmt.Println()
+	}
+
+	//
+	// test matrices
+	log.Infof("Test matrices")
+	matrices, err := readMatrixResults(resultDir, logPath)
+	if err != nil {
+		log.Warnf("Failed to read test matrices, error: %s", err)
+	} else {
+		for _, matrix := range matrices {
+			log.Printf("- %s (%s): %s", matrix.ID, matrix.Outcome, matrix.ConsoleURL)
+			report.MatrixIDs = append(report.MatrixIDs, matrix.ID)
+		}
+
+		outputs, err := matrixOutputs(matrices)
+		if err != nil {
+			failf("Failed to create matrix outputs, error: %s", err)
+		}
+		for _, output := range outputs {
+			if err := exportEnvironmentWithEnvman(output[0], output[1]); err != nil {
+				failf("Failed to export %s, error: %s", output[0], err)
+			}
+			log.Printf("- exported: %s", output[0])
+		}
+		log.Donef("- Done")
+	}
+	fmt.Println()
+
+	//
+	// cost
+	log.Infof("Cost")
+	var costs *costReport
+	if resultDir != "" {
+		if costs, err = readCostReport(resultDir); err != nil {
+			log.Warnf("Failed to read cost report, error: %s", err)
+		}
+	}
+	if costs == nil && len(matrices) > 0 {
+		log.Printf("- %s not found, using the billable minutes of the test matrices", costReportFileName)
+		virtualMinutes, physicalMinutes, cost := matricesCost(matrices)
+		costs = &costReport{VirtualMinutes: virtualMinutes, PhysicalMinutes: physicalMinutes, Cost: cost}
+	}
+
+	if costs != nil {
+		log.Printf("- billable minutes: %d virtual, %d physical, estimated cost: $%.2f", costs.VirtualMinutes, costs.PhysicalMinutes, costs.Cost)
+		report.Cost = costs
+
+		for _, output := range costs.outputs() {
+			if err := exportEnvironmentWithEnvman(output[0], output[1]); err != nil {
+				failf("Failed to export %s, error: %s", output[0], err)
+			}
+			log.Printf("- exported: %s=%s", output[0], output[1])
+		}
+
+		if cfg.CostHistoryPath != "" {
+			entry := costHistoryEntry{
+				Time:            time.Now().UTC().Format(time.RFC3339),
+				Branch:          cfg.GitBranch,
+				Commit:          cfg.GitCommit,
+				BuildNumber:     cfg.BuildNumber,
+				TestName:        testName,
+				VirtualMinutes:  costs.VirtualMinutes,
+				PhysicalMinutes: costs.PhysicalMinutes,
+				Cost:            costs.Cost,
+			}
+			if err := appendCostHistory(cfg.CostHistoryPath, entry); err != nil {
+				log.Warnf("Failed to update cost history, error: %s", err)
+			} else {
+				log.Printf("- appended to: %s", cfg.CostHistoryPath)
+			}
+		}
+		log.Donef("- Done")
+	} else {
+		log.Printf("- no cost report found")
+	}
+	fmt.Println()
+
+	//
+	// exit code policy
+	log.Infof("Exit code policy")
+	policyStatus := exitStatus
+	if quarantined != nil {
+		var released bool
+		if policyStatus, released = releaseQuarantinedFailures(exitStatus, *quarantined, *runSummary); released {
+			log.Printf("- only quarantined tests failed, the run counts as successful")
+		}
+	}
+	if baseline != nil && cfg.NewFailuresOnly {
+		var released bool
+		if policyStatus, released = releaseBaselineFailures(policyStatus, *baseline); released {
+			log.Printf("- only the failures of the baseline failed again, the run counts as successful")
+		}
+	}
+	action, rule := applyExitCodePolicy(exitPolicy, policyStatus, runSummary)
+	if rule != nil {
+		log.Printf("- rule %s:%s applied", rule.Condition, rule.Action)
+	}
+	log.Printf("- flank exit status: %d (%s), step result: %s", exitStatus.Status, exitStatus.Category, action)
+	report.Exit.Action = action
+
+	log.Donef("- Done")
+	fmt.Println()
+
+	//
+	// exporting generated artifacts
 	log.Infof("Exporting artifacts")
-	if err := exportArtifacts("./results", os.Getenv("BITRISE_DEPLOY_DIR"),
-		func(src, dest string) {
-			log.Printf("- copied: %s -> %s", src, dest)
-		},
-	); err != nil {
+	exportPhase := startPhase()
+	logCopied := func(src, dest string) {
+		log.Printf("- copied: %s -> %s", src, dest)
+		report.ExportedFiles = append(report.ExportedFiles, dest)
+	}
+
+	filter := artifactFilter{Include: cfg.ArtifactInclude, Exclude: cfg.ArtifactExclude}
+	if resultDir == "" {
+		log.Printf("- no result dir, skipping the result artifacts")
+	} else if cfg.ZipArtifacts {
+		zipPath := filepath.Join(cfg.DeployDir, artifactsZipFileName)
+		if err := zipArtifacts(resultDir, zipPath, filter); err != nil {
+			failf("Failed to zip artifacts, error: %s", err)
+		}
+		log.Printf("- zipped: %s -> %s", resultDir, zipPath)
+		report.ExportedFiles = append(report.ExportedFiles, zipPath)
+	} else if err := exportArtifacts(resultDir, cfg.DeployDir, filter, logCopied); err != nil {
 		failf("Failed to export artifacts, error: %s", err)
 	}
+
+	if hasTestResults {
+		deployedJUnitPath := filepath.Join(cfg.DeployDir, canonicalJUnitFileName)
+		if err := copyFile(junitPath, deployedJUnitPath); err != nil {
+			failf("Failed to export JUnit report, error: %s", err)
+		}
+		logCopied(junitPath, deployedJUnitPath)
+		if err := exportEnvironmentWithEnvman("FLANK_JUNIT_PATH", deployedJUnitPath); err != nil {
+			failf("Failed to export FLANK_JUNIT_PATH, error: %s", err)
+		}
+		log.Printf("- exported: FLANK_JUNIT_PATH=%s", deployedJUnitPath)
+
+		reportPath := filepath.Join(cfg.DeployDir, htmlReportFileName)
+		if err := writeHTMLReport(reportPath, newHTMLReport(testName, suites, matrices)); err != nil {
+			failf("Failed to write html report, error: %s", err)
+		}
+		log.Printf("- generated: %s", reportPath)
+		report.ExportedFiles = append(report.ExportedFiles, reportPath)
+
+		if cfg.TestResultDir == "" {
+			log.Warnf("BITRISE_TEST_RESULT_DIR is not set, skipping test result export")
+		} else if err := exportTestResults(junitPath, resultDir, cfg.TestResultDir, testName, cfg.ExportDeviceTests, logCopied); err != nil {
+			failf("Failed to export test results, error: %s", err)
+		}
+	}
+
+	mdSummary := markdownSummary{Title: testName, ExitStatus: exitStatus, Action: action, Matrices: matrices, MaxFailures: cfg.SummaryMaxFailures, Cost: costs, Quarantine: quarantine, History: history, Baseline: baseline, Durations: durations}
+	if hasTestResults {
+		mdSummary.Suites = &suites
+	}
+	summaryPath := filepath.Join(cfg.DeployDir, summaryMarkdownFileName)
+	if err := fileutil.WriteStringToFile(summaryPath, renderMarkdownSummary(mdSummary)); err != nil {
+		failf("Failed to write markdown summary, error: %s", err)
+	}
+	log.Printf("- generated: %s", summaryPath)
+	report.ExportedFiles = append(report.ExportedFiles, summaryPath)
+
+	if err := exportEnvironmentWithEnvman("FLANK_SUMMARY_MD_PATH", summaryPath); err != nil {
+		failf("Failed to export FLANK_SUMMARY_MD_PATH, error: %s", err)
+	}
+	log.Printf("- exported: FLANK_SUMMARY_MD_PATH=%s", summaryPath)
+
+	stepReportPath := filepath.Join(cfg.DeployDir, stepReportFileName)
+	report.Phases.ExportSeconds = exportPhase.seconds()
+	if err := writeStepReport(stepReportPath, report); err != nil {
+		failf("Failed to write step report, error: %s", err)
+	}
+	log.Printf("- generated: %s", stepReportPath)
+
+	if err := exportEnvironmentWithEnvman("FLANK_STEP_REPORT_PATH", stepReportPath); err != nil {
+		failf("Failed to export FLANK_STEP_REPORT_PATH, error: %s", err)
+	}
+	log.Printf("- exported: FLANK_STEP_REPORT_PATH=%s", stepReportPath)
 	log.Donef("- Done")
 
-	os.Exit(timeoutcmd.ExitStatus(cmdErr))
+	if action == exitActionWarning {
+		log.Warnf("Flank exited with status code `%d`, the step succeeds as configured by the exit code policy", exitStatus.Status)
+	}
+	os.Exit(stepExitCode(action, exitStatus.Status))
 }
@@ -62,6 +62,25 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
+func Test_validateRunInputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config
+		wantErr bool
+	}{
+		{name: "run inputs set", cfg: config{ServiceAccountJSON: "{}", Version: "latest"}},
+		{name: "no service account", cfg: config{Version: "latest"}, wantErr: true},
+		{name: "no version", cfg: config{ServiceAccountJSON: "{}"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRunInputs(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateRunInputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_detectPlatform(t *testing.T) {
 	tempDir, err := pathutil.NormalizedOSTempDirPath("test")
 	if err != nil {
@@ -190,73 +209,3 @@ func Test_getDownloadURLbyVersion(t *testing.T) {
 		})
 	}
 }
-
-func Test_exportArtifacts(t *testing.T) {
-	srcDir, err := pathutil.NormalizedOSTempDirPath("test-src")
-	if err != nil {
-		t.Fatal(err)
-	}
-	destDir, err := pathutil.NormalizedOSTempDirPath("test-dest")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	if err := createDummyFiles(srcDir, []string{
-		"result-dir-1/res1-file1",
-		"result-dir-1/res1-file2",
-		"result-dir-1/res1-file3",
-		"result-dir-1/res1-ont-include/res1-dont-include-file1",
-		"result-dir-1/res1-dont-include/res1-dont-include-file2",
-		"result-dir-1/res1-dont-include/res1-dont-include-file3",
-		"result-dir-2/res2-file1",
-		"result-dir-2/res2-file2",
-		"result-dir-2/res2-file3",
-		"result-dir-2/res2-dont-include/res2-dont-include-file1",
-		"result-dir-2/res2-dont-include/res2-dont-include-file2",
-		"result-dir-2/res2-dont-include/res2-dont-include-file3",
-	}); err != nil {
-		t.Fatal(err)
-	}
-
-	tests := []struct {
-		name    string
-		srcDir  string
-		destDir string
-		wantErr bool
-	}{
-		{name: "deploy", srcDir: srcDir, destDir: destDir, wantErr: false},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := exportArtifacts(tt.srcDir, tt.destDir, nil); (err != nil) != tt.wantErr {
-				t.Errorf("exportArtifacts() error = %v, wantErr %v", err, tt.wantErr)
-			}
-			fi, err := os.Open(tt.destDir)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			files, err := fi.Readdir(-1)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			expectedFileNames := []string{"res2-file1", "res2-file2", "res2-file3"}
-
-			if len(files) != len(expectedFileNames) {
-				t.Fatal("not all result file copied from result-dir-1 dir")
-			}
-
-			for i, file := range files {
-				if file.IsDir() {
-					t.Fatal("exported artifact cannot be dir")
-				}
-
-				// match the names except the last number
-				if file.Name()[:len(file.Name())-1] != expectedFileNames[i][:len(expectedFileNames[i])-1] {
-					t.Fatal("exported wrong artifact:", file.Name()[:len(file.Name())-1], expectedFileNames[i][:len(expectedFileNames[i])-1])
-				}
-			}
-		})
-	}
-}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const summaryMarkdownFileName = "flank-summary.md"
+
+// markdownSummary holds the data of the markdown build summary, Suites is nil if the run has no test results
+type markdownSummary struct {
+	Title       string
+	ExitStatus  exitClassification
+	Action      exitAction
+	Suites      *junitTestSuites
+	Matrices    []matrixResult
+	MaxFailures int
+	// Cost is the cost report of the run (CostReport.txt or the billable minutes of the matrices), nil if there is none
+	Cost *costReport
+	// Quarantine is the quarantine list, the quarantined failures are listed separately
+	Quarantine []quarantineEntry
+	// History is the comparison with the test history, nil if the history is not kept
+	History *historyReport
+	// Baseline is the comparison with the results of a reference build, nil if there is no baseline
+	Baseline *baselineDiff
+	// Durations are the run and Test Lab times with the duration regressions, nil if the run has no test results
+	Durations *durationReport
+}
+
+func escapeMarkdownTableCell(s string) string {
+	return strings.Replace(s, "|", `\|`, -1)
+}
+
+func inlineCode(s string) string {
+	return "`" + strings.Replace(s, "`", "'", -1) + "`"
+}
+
+func renderMarkdownSummary(s markdownSummary) string {
+	var b strings.Builder
+
+	switch s.Action {
+	case exitActionSuccess:
+		fmt.Fprintf(&b, "## ✅ %s: tests passed\n\n", s.Title)
+	case exitActionWarning:
+		fmt.Fprintf(&b, "## ⚠️ %s: tests passed with warnings\n\n", s.Title)
+	default:
+		fmt.Fprintf(&b, "## ❌ %s: tests failed\n\n", s.Title)
+	}
+	if s.ExitStatus.Category != exitCategorySuccess {
+		fmt.Fprintf(&b, "> Flank exited with status code `%d` (%s)", s.ExitStatus.Status, s.ExitStatus.Category)
+		if s.ExitStatus.Description != "" {
+			fmt.Fprintf(&b, ": %s", s.ExitStatus.Description)
+		}
+		b.WriteString("\n\n")
+	}
+
+	if s.Suites == nil {
+		b.WriteString("No test results were found.\n\n")
+	} else {
+		if len(s.Quarantine) == 0 {
+			summary := summarizeTests(*s.Suites)
+			b.WriteString("| Total | Passed | Failed | Errors | Skipped | Flaky | Time |\n")
+			b.WriteString("|---|---|---|---|---|---|---|\n")
+			fmt.Fprintf(&b, "| %d | %d | %d | %d | %d | %d | %s |\n\n", summary.Total, summary.Passed, summary.Failed, summary.Errors, summary.Skipped, summary.Flaky, formatSeconds(summary.Time))
+		} else {
+			// the counts are the ones the exit code policy is applied to, the quarantined tests are counted separately
+			results, rest := splitQuarantined(*s.Suites, s.Quarantine)
+			summary := summarizeTests(rest)
+			b.WriteString("| Total | Passed | Failed | Errors | Skipped | Flaky | Quarantined | Time |\n")
+			b.WriteString("|---|---|---|---|---|---|---|---|\n")
+			fmt.Fprintf(&b, "| %d | %d | %d | %d | %d | %d | %d | %s |\n\n", summary.Total, summary.Passed, summary.Failed, summary.Errors, summary.Skipped, summary.Flaky, len(results.Passed)+len(results.Failed), formatSeconds(summary.Time))
+		}
+
+		devices := deviceGroups(*s.Suites)
+
+		var failures, quarantined, flaky []reportTestCase
+		for _, tc := range reportTestCases(*s.Suites) {
+			if tc.Status == "failed" || tc.Status == "error" {
+				if isQuarantined(s.Quarantine, tc.ClassName, tc.Name) {
+					quarantined = append(quarantined, tc)
+				} else {
+					failures = append(failures, tc)
+				}
+			}
+			if tc.Flaky {
+				flaky = append(flaky, tc)
+			}
+		}
+
+		if len(failures) > 0 {
+			b.WriteString("### Failing tests\n\n")
+			for i, tc := range failures {
+				if s.MaxFailures > 0 && i == s.MaxFailures {
+					fmt.Fprintf(&b, "- ... and %d more\n", len(failures)-s.MaxFailures)
+					break
+				}
+				fmt.Fprintf(&b, "- %s on %s", inlineCode(tc.ClassName+"#"+tc.Name), tc.Group)
+				if tc.Message != "" {
+					fmt.Fprintf(&b, ": %s", inlineCode(tc.Message))
+				}
+				b.WriteString("\n")
+			}
+			b.WriteString("\n")
+		}
+
+		if len(quarantined) > 0 {
+			b.WriteString("### Quarantined failing tests\n\n")
+			for _, tc := range quarantined {
+				fmt.Fprintf(&b, "- %s on %s\n", inlineCode(tc.ClassName+"#"+tc.Name), tc.Group)
+			}
+			b.WriteString("\n")
+		}
+
+		if len(devices) > 0 {
+			b.WriteString("### Devices\n\n")
+			b.WriteString("| Device | Tests | Failed | Errors | Skipped | Flaky | Time |\n")
+			b.WriteString("|---|---|---|---|---|---|---|\n")
+			for _, group := range devices {
+				fmt.Fprintf(&b, "| %s | %d | %d | %d | %d | %d | %s |\n", escapeMarkdownTableCell(group.Name), group.Summary.Total, group.Summary.Failed, group.Summary.Errors, group.Summary.Skipped, group.Summary.Flaky, formatSeconds(group.Summary.Time))
+			}
+			b.WriteString("\n")
+		}
+
+		if len(flaky) > 0 {
+			b.WriteString("### Flaky tests\n\n")
+			for _, tc := range flaky {
+				fmt.Fprintf(&b, "- %s on %s\n", inlineCode(tc.ClassName+"#"+tc.Name), tc.Group)
+			}
+			b.WriteString("\n")
+		}
+
+		if s.Baseline != nil {
+			d := s.Baseline
+			b.WriteString("### Compared with the baseline\n\n")
+			b.WriteString("| New failures | Fixed | Still failing | Added | Removed | Slower |\n")
+			b.WriteString("|---|---|---|---|---|---|\n")
+			if d.Partial {
+				fmt.Fprintf(&b, "| %d | %d | %d | - | - | - |\n\n", len(d.NewFailures), len(d.Fixed), len(d.StillFailing))
+			} else {
+				fmt.Fprintf(&b, "| %d | %d | %d | %d | %d | %d |\n\n", len(d.NewFailures), len(d.Fixed), len(d.StillFailing), len(d.Added), len(d.Removed), len(d.Slower))
+			}
+			for _, group := range []struct {
+				title string
+				names []string
+			}{
+				{"New failures", d.NewFailures},
+				{"Fixed", d.Fixed},
+			} {
+				if len(group.names) == 0 {
+					continue
+				}
+				fmt.Fprintf(&b, "%s:\n", group.title)
+				for _, name := range group.names {
+					fmt.Fprintf(&b, "- %s\n", inlineCode(name))
+				}
+				b.WriteString("\n")
+			}
+			if len(d.Slower) > 0 {
+				b.WriteString("Slower:\n")
+				for _, test := range d.Slower {
+					fmt.Fprintf(&b, "- %s: %s -> %s\n", inlineCode(test.Name), formatSeconds(test.BaselineSeconds), formatSeconds(test.Seconds))
+				}
+				b.WriteString("\n")
+			}
+		}
+
+		if s.Durations != nil {
+			d := s.Durations
+			b.WriteString("### Durations\n\n")
+			fmt.Fprintf(&b, "Flank run: %s. Test Lab execution: %s (longest of %d shards).\n\n", formatSeconds(d.RunSeconds), formatSeconds(d.TestLabSeconds), d.Shards)
+			for _, group := range []struct {
+				title       string
+				regressions []durationRegression
+			}{
+				{"Slower tests", d.RegressedTests},
+				{"Slower shards", d.RegressedShards},
+			} {
+				if len(group.regressions) == 0 {
+					continue
+				}
+				fmt.Fprintf(&b, "%s:\n", group.title)
+				for _, r := range group.regressions {
+					fmt.Fprintf(&b, "- %s: %s -> %s\n", inlineCode(r.Name), formatSeconds(r.BaselineSeconds), formatSeconds(r.Seconds))
+				}
+				b.WriteString("\n")
+			}
+		}
+
+		if s.History != nil {
+			for _, section := range []struct {
+				title string
+				names []string
+			}{
+				{"Newly failing tests", s.History.NewlyFailing},
+				{"Newly flaky tests", s.History.NewlyFlaky},
+			} {
+				if len(section.names) == 0 {
+					continue
+				}
+				fmt.Fprintf(&b, "### %s\n\n", section.title)
+				for _, name := range section.names {
+					fmt.Fprintf(&b, "- %s\n", inlineCode(name))
+				}
+				b.WriteString("\n")
+			}
+
+			if len(s.History.TopFlaky) > 0 {
+				fmt.Fprintf(&b, "### Top flaky tests on %s\n\n", s.History.Branch)
+				b.WriteString("| Test | Flaky runs | Runs |\n")
+				b.WriteString("|---|---|---|\n")
+				for _, test := range s.History.TopFlaky {
+					fmt.Fprintf(&b, "| %s | %d | %d |\n", escapeMarkdownTableCell(inlineCode(test.Name)), test.Flaky, test.Runs)
+				}
+				b.WriteString("\n")
+			}
+		}
+	}
+
+	if len(s.Matrices) > 0 {
+		b.WriteString("### Test matrices\n\n")
+		for _, m := range s.Matrices {
+			if m.ConsoleURL != "" {
+				fmt.Fprintf(&b, "- [%s](%s): %s\n", m.ID, m.ConsoleURL, m.Outcome)
+			} else {
+				fmt.Fprintf(&b, "- %s: %s\n", m.ID, m.Outcome)
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	if s.Cost != nil {
+		b.WriteString("### Cost\n\n")
+		fmt.Fprintf(&b, "Billable minutes: %d virtual, %d physical. Estimated cost: $%.2f\n", s.Cost.VirtualMinutes, s.Cost.PhysicalMinutes, s.Cost.Cost)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_renderMarkdownSummary(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-markdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := parseJUnitReport(writeTestFile(t, tmpDir, junitReportFileName, testJUnitReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites := canonicalSuitesOfReport(report)
+	legacySuites := canonicalSuitesOfReport(junitTestSuites{Suites: []junitTestSuite{
+		{Name: "matrix-1", TestCases: []junitTestCase{{Name: "testFail", ClassName: "com.example.MainTest", Failures: []junitMessage{{Message: "assertion failed"}}}}},
+	}})
+	matrices := []matrixResult{
+		{ID: "matrix-1", Outcome: "failure", ConsoleURL: "https://console.firebase.google.com/matrix-1", BillableVirtualMinutes: 3, BillablePhysicalMinutes: 6},
+	}
+
+	tests := []struct {
+		name        string
+		summary     markdownSummary
+		contains    []string
+		notContains []string
+	}{
+		{
+			name: "failed run",
+			summary: markdownSummary{Title: "android - flank", ExitStatus: classifyExitStatus("v8.1.0", 10, nil), Action: exitActionFailure, Suites: &suites, Matrices: matrices, MaxFailures: 1,
+				Cost: &costReport{VirtualMinutes: 3, PhysicalMinutes: 6, Cost: 0.55}},
+			contains: []string{
+				"## ❌ android - flank: tests failed",
+				"> Flank exited with status code `10` (test_failure): At least one test failed or was inconclusive.",
+				"| 6 | 3 | 1 | 1 | 1 | 1 | 14.000s |",
+				"- `com.example.MainTest#testFail` on NexusLowRes-28-en-portrait: `java.lang.AssertionError: expected:<1> but was:<2>`",
+				"- ... and 1 more",
+				"| Pixel2-29-en-portrait | 1 | 0 | 0 | 0 | 0 | 1.500s |",
+				"### Flaky tests\n\n- `com.example.MainTest#testFlaky` on NexusLowRes-28-en-portrait",
+				"- [matrix-1](https://console.firebase.google.com/matrix-1): failure",
+				"Billable minutes: 3 virtual, 6 physical. Estimated cost: $0.55",
+			},
+		},
+		{
+			name: "quarantined failure",
+			summary: markdownSummary{Title: "android - flank", ExitStatus: classifyExitStatus("v8.1.0", 10, nil), Action: exitActionFailure, Suites: &suites,
+				Quarantine: []quarantineEntry{{Class: "com.example.MainTest", Method: "testFail"}}},
+			contains: []string{
+				"| Total | Passed | Failed | Errors | Skipped | Flaky | Quarantined | Time |\n|---|---|---|---|---|---|---|---|\n| 5 | 3 | 0 | 1 | 1 | 1 | 1 | 5.500s |\n",
+				"### Failing tests\n\n- `com.example.OtherTest#testCrash` on NexusLowRes-28-en-portrait: `Process crashed.`\n\n",
+				"### Quarantined failing tests\n\n- `com.example.MainTest#testFail` on NexusLowRes-28-en-portrait\n",
+			},
+		},
+		{
+			name: "test history",
+			summary: markdownSummary{Title: "android - flank", ExitStatus: classifyExitStatus("v8.1.0", 0, nil), Action: exitActionSuccess, Suites: &suites,
+				History: &historyReport{Branch: "main", NewlyFlaky: []string{"com.example.MainTest#testFlaky"}, NewlyFailing: []string{},
+					TopFlaky: []flakyTest{{Name: "com.example.MainTest#testFlaky", Flaky: 3, Runs: 10}}}},
+			contains: []string{
+				"### Newly flaky tests\n\n- `com.example.MainTest#testFlaky`\n",
+				"### Top flaky tests on main\n\n| Test | Flaky runs | Runs |\n|---|---|---|\n| `com.example.MainTest#testFlaky` | 3 | 10 |\n",
+			},
+			notContains: []string{"### Newly failing tests"},
+		},
+		{
+			name: "durations",
+			summary: markdownSummary{Title: "android - flank", ExitStatus: classifyExitStatus("v8.1.0", 0, nil), Action: exitActionSuccess, Suites: &suites,
+				Durations: &durationReport{RunSeconds: 300, TestLabSeconds: 120, Shards: 2,
+					RegressedTests: []durationRegression{{Name: "com.example.MainTest#testPass", BaselineSeconds: 1, Seconds: 20}}}},
+			contains: []string{
+				"### Durations\n\nFlank run: 300.000s. Test Lab execution: 120.000s (longest of 2 shards).\n\n",
+				"Slower tests:\n- `com.example.MainTest#testPass`: 1.000s -> 20.000s\n",
+			},
+			notContains: []string{"Slower shards"},
+		},
+		{
+			name: "cost report without matrices",
+			summary: markdownSummary{Title: "ios - flank", ExitStatus: classifyExitStatus("v20.08.0", 0, nil), Action: exitActionSuccess,
+				Cost: &costReport{VirtualMinutes: 0, PhysicalMinutes: 12, Cost: 1}},
+			contains:    []string{"### Cost\n\nBillable minutes: 0 virtual, 12 physical. Estimated cost: $1.00\n"},
+			notContains: []string{"### Test matrices"},
+		},
+		{
+			name:        "legacy junit mode",
+			summary:     markdownSummary{Title: "android - flank", ExitStatus: classifyExitStatus("v8.1.0", 10, nil), Action: exitActionFailure, Suites: &legacySuites},
+			contains:    []string{"### Failing tests\n\n- `com.example.MainTest#testFail` on matrix-1: `assertion failed`\n"},
+			notContains: []string{"### Devices"},
+		},
+		{
+			name:        "no results",
+			summary:     markdownSummary{Title: "ios - flank", ExitStatus: classifyExitStatus("v20.08.0", 0, nil), Action: exitActionSuccess},
+			contains:    []string{"## ✅ ios - flank: tests passed", "No test results were found."},
+			notContains: []string{"### Cost", "### Failing tests"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderMarkdownSummary(tt.summary)
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("summary does not contain %q:\n%s", want, got)
+				}
+			}
+			for _, notWant := range tt.notContains {
+				if strings.Contains(got, notWant) {
+					t.Errorf("summary contains %q:\n%s", notWant, got)
+				}
+			}
+		})
+	}
+}
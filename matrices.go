@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	matrixIDsFileName = "matrix_ids.json"
+	gcsBrowserURL     = "https://console.developers.google.com/storage/browser/"
+)
+
+var (
+	matrixWebLinkPattern    = regexp.MustCompile(`(matrix-[a-z0-9]+)\s+(https://console\.firebase\.google\.com/\S+)`)
+	matrixOutcomePattern    = regexp.MustCompile(`│\s*([a-z]+)\s*│\s*(matrix-[a-z0-9]+)\s*│`)
+	matrixRawResultsPattern = regexp.MustCompile(`(https://console\.developers\.google\.com/storage/browser/[^\s\]]+)`)
+)
+
+// savedMatrix is an item of the matrix_ids.json written by flank
+type savedMatrix struct {
+	MatrixID                string `json:"matrixId"`
+	State                   string `json:"state"`
+	GcsPath                 string `json:"gcsPath"`
+	WebLink                 string `json:"webLink"`
+	Outcome                 string `json:"outcome"`
+	OutcomeDetails          string `json:"outcomeDetails"`
+	BillablePhysicalMinutes int64  `json:"billablePhysicalMinutes"`
+	BillableVirtualMinutes  int64  `json:"billableVirtualMinutes"`
+}
+
+// matrixResult is the step's view of a test matrix, exported as FLANK_MATRICES_JSON
+type matrixResult struct {
+	ID                      string `json:"id"`
+	State                   string `json:"state,omitempty"`
+	Outcome                 string `json:"outcome,omitempty"`
+	ConsoleURL              string `json:"console_url,omitempty"`
+	ResultsURL              string `json:"results_url,omitempty"`
+	BillableVirtualMinutes  int64  `json:"billable_virtual_minutes,omitempty"`
+	BillablePhysicalMinutes int64  `json:"billable_physical_minutes,omitempty"`
+}
+
+// reads the matrix_ids.json of the result dir, a missing file means no matrices
+func readSavedMatrices(resultDir string) ([]savedMatrix, error) {
+	pth := filepath.Join(resultDir, matrixIDsFileName)
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, nil
+	}
+
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	var byID map[string]savedMatrix
+	if err := json.Unmarshal(data, &byID); err != nil {
+		return nil, err
+	}
+
+	var matrices []savedMatrix
+	for id, m := range byID {
+		if m.MatrixID == "" {
+			m.MatrixID = id
+		}
+		matrices = append(matrices, m)
+	}
+	sort.Slice(matrices, func(i, j int) bool { return matrices[i].MatrixID < matrices[j].MatrixID })
+	return matrices, nil
+}
+
+// collects the matrix web links, outcomes and the raw results url printed by flank
+func parseMatricesFromOutput(r io.Reader) (map[string]*matrixResult, string, error) {
+	byID := map[string]*matrixResult{}
+	get := func(id string) *matrixResult {
+		if _, ok := byID[id]; !ok {
+			byID[id] = &matrixResult{ID: id}
+		}
+		return byID[id]
+	}
+
+	var rawResultsURL string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := ansiEscapePattern.ReplaceAllString(scanner.Text(), "")
+
+		if match := matrixWebLinkPattern.FindStringSubmatch(line); match != nil {
+			get(match[1]).ConsoleURL = match[2]
+		}
+		if match := matrixOutcomePattern.FindStringSubmatch(line); match != nil {
+			get(match[2]).Outcome = match[1]
+		}
+		if match := matrixRawResultsPattern.FindStringSubmatch(line); match != nil && rawResultsURL == "" {
+			rawResultsURL = match[1]
+		}
+	}
+	return byID, rawResultsURL, scanner.Err()
+}
+
+// merges the matrix_ids.json content with the matrices found in flank's output,
+// the json is preferred, the output is used to fill the missing fields and matrices
+func collectMatrixResults(saved []savedMatrix, fromOutput map[string]*matrixResult, rawResultsURL string) []matrixResult {
+	var results []matrixResult
+	seen := map[string]bool{}
+
+	for _, m := range saved {
+		result := matrixResult{
+			ID:                      m.MatrixID,
+			State:                   m.State,
+			Outcome:                 m.Outcome,
+			ConsoleURL:              m.WebLink,
+			BillableVirtualMinutes:  m.BillableVirtualMinutes,
+			BillablePhysicalMinutes: m.BillablePhysicalMinutes,
+		}
+		if m.GcsPath != "" {
+			result.ResultsURL = gcsBrowserURL + strings.TrimPrefix(m.GcsPath, "gs://")
+		}
+		if fallback, ok := fromOutput[m.MatrixID]; ok {
+			if result.Outcome == "" {
+				result.Outcome = fallback.Outcome
+			}
+			if result.ConsoleURL == "" {
+				result.ConsoleURL = fallback.ConsoleURL
+			}
+		}
+		if result.ResultsURL == "" {
+			result.ResultsURL = rawResultsURL
+		}
+		results = append(results, result)
+		seen[m.MatrixID] = true
+	}
+
+	var ids []string
+	for id := range fromOutput {
+		if !seen[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		result := *fromOutput[id]
+		result.ResultsURL = rawResultsURL
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// reads the matrices from the result dir's matrix_ids.json and the flank log,
+// resultDir is empty if flank did not create one, then only the log is read
+func readMatrixResults(resultDir, logPath string) ([]matrixResult, error) {
+	var saved []savedMatrix
+	if resultDir != "" {
+		var err error
+		if saved, err = readSavedMatrices(resultDir); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file, error: %s", err)
+		}
+	}()
+
+	fromOutput, rawResultsURL, err := parseMatricesFromOutput(f)
+	if err != nil {
+		return nil, err
+	}
+	return collectMatrixResults(saved, fromOutput, rawResultsURL), nil
+}
+
+func matrixOutputs(results []matrixResult) ([][2]string, error) {
+	var ids, urls []string
+	for _, result := range results {
+		ids = append(ids, result.ID)
+		if result.ConsoleURL != "" {
+			urls = append(urls, result.ConsoleURL)
+		}
+	}
+
+	if results == nil {
+		results = []matrixResult{}
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][2]string{
+		{"FLANK_MATRIX_IDS", strings.Join(ids, "\n")},
+		{"FLANK_CONSOLE_URLS", strings.Join(urls, "\n")},
+		{"FLANK_MATRICES_JSON", string(data)},
+	}, nil
+}
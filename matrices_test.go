@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const testFlankOutput = `[2019-04-01 10:20:30] RunTests
+[2019-04-01 10:20:30]   Raw results will be stored in your GCS bucket at [https://console.developers.google.com/storage/browser/test-lab-abc/2019-04-01_10-20-30/]
+[2019-04-01 10:20:31]   Matrices webLink
+[2019-04-01 10:20:31]     matrix-1 https://console.firebase.google.com/project/demo/testlab/histories/bh.1/matrices/1
+[2019-04-01 10:20:31]     matrix-2 https://console.firebase.google.com/project/demo/testlab/histories/bh.1/matrices/2
+[2019-04-01 10:25:00] ┌─────────┬──────────┬──────────────────────┐
+[2019-04-01 10:25:00] │ OUTCOME │ MATRIX ID│     TEST DETAILS     │
+[2019-04-01 10:25:00] ├─────────┼──────────┼──────────────────────┤
+[2019-04-01 10:25:00] │ success │ matrix-1 │ 2 test cases passed  │
+[2019-04-01 10:25:00] │ failure │ matrix-2 │ 1 test cases failed  │
+[2019-04-01 10:25:00] └─────────┴──────────┴──────────────────────┘
+`
+
+func Test_parseMatricesFromOutput(t *testing.T) {
+	byID, rawResultsURL, err := parseMatricesFromOutput(strings.NewReader(testFlankOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rawResultsURL != "https://console.developers.google.com/storage/browser/test-lab-abc/2019-04-01_10-20-30/" {
+		t.Errorf("rawResultsURL = %s", rawResultsURL)
+	}
+
+	want := map[string]*matrixResult{
+		"matrix-1": {ID: "matrix-1", Outcome: "success", ConsoleURL: "https://console.firebase.google.com/project/demo/testlab/histories/bh.1/matrices/1"},
+		"matrix-2": {ID: "matrix-2", Outcome: "failure", ConsoleURL: "https://console.firebase.google.com/project/demo/testlab/histories/bh.1/matrices/2"},
+	}
+	if !reflect.DeepEqual(byID, want) {
+		t.Errorf("parseMatricesFromOutput() = %+v, want %+v", byID, want)
+	}
+}
+
+func Test_readMatrixResults(t *testing.T) {
+	resultDir, err := pathutil.NormalizedOSTempDirPath("test-matrices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logPath := writeTestFile(t, resultDir, "flank.log", testFlankOutput)
+
+	t.Run("output only", func(t *testing.T) {
+		got, err := readMatrixResults(resultDir, logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].ID != "matrix-1" || got[1].ResultsURL == "" {
+			t.Errorf("readMatrixResults() = %+v", got)
+		}
+	})
+
+	t.Run("matrix_ids.json preferred", func(t *testing.T) {
+		writeTestFile(t, resultDir, matrixIDsFileName, `{
+  "matrix-1": {
+    "matrixId": "matrix-1",
+    "state": "FINISHED",
+    "gcsPath": "test-lab-abc/2019-04-01_10-20-30/shard_0/",
+    "webLink": "",
+    "outcome": "flaky",
+    "billableVirtualMinutes": 2
+  }
+}`)
+
+		got, err := readMatrixResults(resultDir, logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []matrixResult{
+			{
+				ID:                     "matrix-1",
+				State:                  "FINISHED",
+				Outcome:                "flaky",
+				ConsoleURL:             "https://console.firebase.google.com/project/demo/testlab/histories/bh.1/matrices/1",
+				ResultsURL:             "https://console.developers.google.com/storage/browser/test-lab-abc/2019-04-01_10-20-30/shard_0/",
+				BillableVirtualMinutes: 2,
+			},
+			{
+				ID:         "matrix-2",
+				Outcome:    "failure",
+				ConsoleURL: "https://console.firebase.google.com/project/demo/testlab/histories/bh.1/matrices/2",
+				ResultsURL: "https://console.developers.google.com/storage/browser/test-lab-abc/2019-04-01_10-20-30/",
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("readMatrixResults() = %+v, want %+v", got, want)
+		}
+
+		outputs, err := matrixOutputs(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if outputs[0][1] != "matrix-1\nmatrix-2" {
+			t.Errorf("FLANK_MATRIX_IDS = %s", outputs[0][1])
+		}
+	})
+}
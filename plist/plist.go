@@ -0,0 +1,473 @@
+// Package plist decodes XML and binary property lists into Go values:
+// map[string]interface{}, []interface{}, string, bool, int64, float64, []byte and time.Time,
+// and encodes these values into XML property lists.
+package plist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	binaryMagic = "bplist00"
+	maxDepth    = 64
+)
+
+// the reference date of the binary plist dates
+var appleEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Decode decodes a property list, the format (XML or binary) is detected from the content
+func Decode(data []byte) (interface{}, error) {
+	if bytes.HasPrefix(data, []byte(binaryMagic)) {
+		return decodeBinary(data)
+	}
+	return decodeXML(data)
+}
+
+//
+// XML
+
+func decodeXML(data []byte) (interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	// the DOCTYPE and the xml header are skipped by the token loop
+	d.Strict = false
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("no plist element found")
+			}
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if start.Name.Local != "plist" {
+				return nil, fmt.Errorf("unexpected root element: %s", start.Name.Local)
+			}
+			value, err := nextXMLValue(d, 0)
+			if err != nil {
+				return nil, err
+			}
+			return value, nil
+		}
+	}
+}
+
+// returns the value of the next element, nil if the parent element ends
+func nextXMLValue(d *xml.Decoder, depth int) (interface{}, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return xmlValue(d, t, depth)
+		case xml.EndElement:
+			return nil, nil
+		}
+	}
+}
+
+func xmlText(d *xml.Decoder, start xml.StartElement) (string, error) {
+	var text string
+	if err := d.DecodeElement(&text, &start); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+func xmlValue(d *xml.Decoder, start xml.StartElement, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("plist is nested too deep")
+	}
+
+	switch start.Name.Local {
+	case "dict":
+		dict := map[string]interface{}{}
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.EndElement:
+				return dict, nil
+			case xml.StartElement:
+				if t.Name.Local != "key" {
+					return nil, fmt.Errorf("expected key in dict, got: %s", t.Name.Local)
+				}
+				key, err := xmlText(d, t)
+				if err != nil {
+					return nil, err
+				}
+				value, err := nextXMLValue(d, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				if value == nil {
+					return nil, fmt.Errorf("missing value of key: %s", key)
+				}
+				dict[key] = value
+			}
+		}
+	case "array":
+		array := []interface{}{}
+		for {
+			value, err := nextXMLValue(d, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				return array, nil
+			}
+			array = append(array, value)
+		}
+	case "true", "false":
+		if err := d.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	text, err := xmlText(d, start)
+	if err != nil {
+		return nil, err
+	}
+	text = strings.TrimSpace(text)
+
+	switch start.Name.Local {
+	case "string":
+		return text, nil
+	case "integer":
+		return strconv.ParseInt(text, 10, 64)
+	case "real":
+		return strconv.ParseFloat(text, 64)
+	case "date":
+		return time.Parse(time.RFC3339, text)
+	case "data":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	default:
+		return nil, fmt.Errorf("unknown plist element: %s", start.Name.Local)
+	}
+}
+
+//
+// binary
+
+type binaryPlist struct {
+	data          []byte
+	offsetIntSize int
+	objectRefSize int
+	offsets       []uint64
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func decodeBinary(data []byte) (interface{}, error) {
+	if len(data) < len(binaryMagic)+32 {
+		return nil, fmt.Errorf("binary plist is too short")
+	}
+	trailer := data[len(data)-32:]
+	p := binaryPlist{
+		data:          data,
+		offsetIntSize: int(trailer[6]),
+		objectRefSize: int(trailer[7]),
+	}
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	topObject := binary.BigEndian.Uint64(trailer[16:])
+	offsetTableOffset := binary.BigEndian.Uint64(trailer[24:])
+
+	if p.offsetIntSize < 1 || p.offsetIntSize > 8 || p.objectRefSize < 1 || p.objectRefSize > 8 {
+		return nil, fmt.Errorf("invalid binary plist trailer")
+	}
+	if offsetTableOffset > uint64(len(data)) || p.fits(numObjects, uint64(p.offsetIntSize), offsetTableOffset) != nil {
+		return nil, fmt.Errorf("invalid binary plist offset table")
+	}
+	for i := uint64(0); i < numObjects; i++ {
+		start := offsetTableOffset + i*uint64(p.offsetIntSize)
+		p.offsets = append(p.offsets, readUint(data[start:start+uint64(p.offsetIntSize)]))
+	}
+	return p.object(topObject, 0)
+}
+
+// returns an error if n items of the given size do not fit in the data after off, it is checked before
+// multiplying or allocating by a length read from the data, so that a corrupt length can not overflow them
+func (p binaryPlist) fits(n, size, off uint64) error {
+	if off > uint64(len(p.data)) || n > (uint64(len(p.data))-off)/size {
+		return fmt.Errorf("length %d at %d out of range", n, off)
+	}
+	return nil
+}
+
+func (p binaryPlist) bytes(off, n uint64) ([]byte, error) {
+	if off+n > uint64(len(p.data)) || off+n < off {
+		return nil, fmt.Errorf("object at %d out of range", off)
+	}
+	return p.data[off : off+n], nil
+}
+
+// returns the length of the object with the given marker and the offset of its content
+func (p binaryPlist) length(marker byte, off uint64) (uint64, uint64, error) {
+	if marker&0x0f != 0x0f {
+		return uint64(marker & 0x0f), off + 1, nil
+	}
+	intMarker, err := p.bytes(off+1, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if intMarker[0]&0xf0 != 0x10 {
+		return 0, 0, fmt.Errorf("invalid length at %d", off)
+	}
+	size := uint64(1) << (intMarker[0] & 0x0f)
+	b, err := p.bytes(off+2, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	return readUint(b), off + 2 + size, nil
+}
+
+func (p binaryPlist) refs(off, n uint64) ([]uint64, error) {
+	if err := p.fits(n, uint64(p.objectRefSize), off); err != nil {
+		return nil, err
+	}
+	b, err := p.bytes(off, n*uint64(p.objectRefSize))
+	if err != nil {
+		return nil, err
+	}
+	var refs []uint64
+	for i := uint64(0); i < n; i++ {
+		refs = append(refs, readUint(b[i*uint64(p.objectRefSize):(i+1)*uint64(p.objectRefSize)]))
+	}
+	return refs, nil
+}
+
+func (p binaryPlist) object(ref uint64, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("plist is nested too deep")
+	}
+	if ref >= uint64(len(p.offsets)) {
+		return nil, fmt.Errorf("object reference %d out of range", ref)
+	}
+	off := p.offsets[ref]
+	m, err := p.bytes(off, 1)
+	if err != nil {
+		return nil, err
+	}
+	marker := m[0]
+
+	switch marker & 0xf0 {
+	case 0x00:
+		switch marker {
+		case 0x08:
+			return false, nil
+		case 0x09:
+			return true, nil
+		}
+		return nil, nil
+	case 0x10:
+		size := uint64(1) << (marker & 0x0f)
+		b, err := p.bytes(off+1, size)
+		if err != nil {
+			return nil, err
+		}
+		return int64(readUint(b)), nil
+	case 0x20:
+		size := uint64(1) << (marker & 0x0f)
+		b, err := p.bytes(off+1, size)
+		if err != nil {
+			return nil, err
+		}
+		if size == 4 {
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+		}
+		return math.Float64frombits(readUint(b)), nil
+	case 0x30:
+		b, err := p.bytes(off+1, 8)
+		if err != nil {
+			return nil, err
+		}
+		seconds := math.Float64frombits(binary.BigEndian.Uint64(b))
+		return appleEpoch.Add(time.Duration(seconds * float64(time.Second))), nil
+	case 0x40, 0x50, 0x60:
+		n, start, err := p.length(marker, off)
+		if err != nil {
+			return nil, err
+		}
+		switch marker & 0xf0 {
+		case 0x40:
+			return p.bytes(start, n)
+		case 0x50:
+			b, err := p.bytes(start, n)
+			if err != nil {
+				return nil, err
+			}
+			return string(b), nil
+		default:
+			if err := p.fits(n, 2, start); err != nil {
+				return nil, err
+			}
+			b, err := p.bytes(start, n*2)
+			if err != nil {
+				return nil, err
+			}
+			units := make([]uint16, n)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(b[i*2:])
+			}
+			return string(utf16.Decode(units)), nil
+		}
+	case 0xa0:
+		n, start, err := p.length(marker, off)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := p.refs(start, n)
+		if err != nil {
+			return nil, err
+		}
+		array := []interface{}{}
+		for _, r := range refs {
+			value, err := p.object(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		return array, nil
+	case 0xd0:
+		n, start, err := p.length(marker, off)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.fits(n, 2, start); err != nil {
+			return nil, err
+		}
+		refs, err := p.refs(start, n*2)
+		if err != nil {
+			return nil, err
+		}
+		dict := map[string]interface{}{}
+		for i := uint64(0); i < n; i++ {
+			key, err := p.object(refs[i], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("dict key is not a string: %v", key)
+			}
+			value, err := p.object(refs[n+i], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			dict[k] = value
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unsupported object type %#x", marker)
+	}
+}
+
+//
+// encoding
+
+// EncodeXML encodes the value into an XML property list, the values Decode returns are supported
+func EncodeXML(value interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0">` + "\n")
+	if err := encodeXMLValue(&b, value, 0); err != nil {
+		return nil, err
+	}
+	b.WriteString("</plist>\n")
+	return b.Bytes(), nil
+}
+
+func writeXMLElement(b *bytes.Buffer, indent, name, text string) error {
+	b.WriteString(indent + "<" + name + ">")
+	if err := xml.EscapeText(b, []byte(text)); err != nil {
+		return err
+	}
+	b.WriteString("</" + name + ">\n")
+	return nil
+}
+
+func encodeXMLValue(b *bytes.Buffer, value interface{}, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("plist is nested too deep")
+	}
+	indent := strings.Repeat("\t", depth)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			b.WriteString(indent + "<dict/>\n")
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b.WriteString(indent + "<dict>\n")
+		for _, key := range keys {
+			if err := writeXMLElement(b, indent+"\t", "key", key); err != nil {
+				return err
+			}
+			if err := encodeXMLValue(b, v[key], depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteString(indent + "</dict>\n")
+	case []interface{}:
+		if len(v) == 0 {
+			b.WriteString(indent + "<array/>\n")
+			return nil
+		}
+		b.WriteString(indent + "<array>\n")
+		for _, item := range v {
+			if err := encodeXMLValue(b, item, depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteString(indent + "</array>\n")
+	case string:
+		return writeXMLElement(b, indent, "string", v)
+	case bool:
+		if v {
+			b.WriteString(indent + "<true/>\n")
+		} else {
+			b.WriteString(indent + "<false/>\n")
+		}
+	case int64:
+		return writeXMLElement(b, indent, "integer", strconv.FormatInt(v, 10))
+	case float64:
+		return writeXMLElement(b, indent, "real", strconv.FormatFloat(v, 'g', -1, 64))
+	case []byte:
+		return writeXMLElement(b, indent, "data", base64.StdEncoding.EncodeToString(v))
+	case time.Time:
+		return writeXMLElement(b, indent, "date", v.UTC().Format(time.RFC3339))
+	default:
+		return fmt.Errorf("unsupported plist value: %T", value)
+	}
+	return nil
+}
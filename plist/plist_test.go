@@ -0,0 +1,185 @@
+package plist
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testXMLPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>TestConfigurations</key>
+	<array>
+		<dict>
+			<key>Name</key>
+			<string>Configuration 1</string>
+			<key>IsUITestBundle</key>
+			<true/>
+			<key>Retries</key>
+			<integer>2</integer>
+			<key>Ratio</key>
+			<real>0.5</real>
+			<key>Skip</key>
+			<array/>
+		</dict>
+	</array>
+	<key>Created</key>
+	<date>2020-08-10T10:00:00Z</date>
+	<key>Data</key>
+	<data>
+	aGVs
+	bG8=
+	</data>
+	<key>Empty</key>
+	<string></string>
+	<key>Disabled</key>
+	<false/>
+</dict>
+</plist>
+`
+
+func TestDecode_XML(t *testing.T) {
+	got, err := Decode([]byte(testXMLPlist))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"TestConfigurations": []interface{}{
+			map[string]interface{}{
+				"Name":           "Configuration 1",
+				"IsUITestBundle": true,
+				"Retries":        int64(2),
+				"Ratio":          0.5,
+				"Skip":           []interface{}{},
+			},
+		},
+		"Created":  time.Date(2020, 8, 10, 10, 0, 0, 0, time.UTC),
+		"Data":     []byte("hello"),
+		"Empty":    "",
+		"Disabled": false,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %#v, want %#v", got, want)
+	}
+
+	if _, err := Decode([]byte(`<dict><key>a</key></dict>`)); err == nil {
+		t.Error("Decode() of a non plist xml error = nil")
+	}
+}
+
+// encodes the objects into a binary plist with 1 byte object references, the first object is the top object
+func buildBinaryPlist(objects [][]byte) []byte {
+	out := []byte("bplist00")
+	var offsets []uint16
+	for _, object := range objects {
+		offsets = append(offsets, uint16(len(out)))
+		out = append(out, object...)
+	}
+	offsetTableOffset := len(out)
+	for _, off := range offsets {
+		out = append(out, byte(off>>8), byte(off))
+	}
+
+	trailer := make([]byte, 32)
+	trailer[6] = 2
+	trailer[7] = 1
+	binary.BigEndian.PutUint64(trailer[8:], uint64(len(objects)))
+	binary.BigEndian.PutUint64(trailer[24:], uint64(offsetTableOffset))
+	return append(out, trailer...)
+}
+
+func TestDecode_Binary(t *testing.T) {
+	data := buildBinaryPlist([][]byte{
+		// dict of 3: keys 1, 2, 3; values 4, 5, 6
+		{0xd3, 1, 2, 3, 4, 5, 6},
+		append([]byte{0x54}, "Name"...),
+		append([]byte{0x5e}, "IsUITestBundle"...),
+		append([]byte{0x55}, "Items"...),
+		// UTF-16 string of 2 characters
+		{0x62, 0x00, 'U', 0x00, 'I'},
+		{0x09},
+		// array of 2: objects 7, 8
+		{0xa2, 7, 8},
+		{0x11, 0x01, 0x00},
+		// string with an extended length: int object of 1 byte (0x10) with the value of 16
+		append([]byte{0x5f, 0x10, 16}, "MyAppUITests.app"...),
+	})
+
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"Name":           "UI",
+		"IsUITestBundle": true,
+		"Items":          []interface{}{int64(256), "MyAppUITests.app"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %#v, want %#v", got, want)
+	}
+
+	if _, err := Decode(data[:len(data)-40]); err == nil {
+		t.Error("Decode() of a truncated binary plist error = nil")
+	}
+}
+
+func TestDecode_CorruptBinary(t *testing.T) {
+	valid := buildBinaryPlist([][]byte{{0x09}})
+	withTrailer := func(offset int, value uint64) []byte {
+		data := append([]byte{}, valid...)
+		binary.BigEndian.PutUint64(data[len(data)-32+offset:], value)
+		return data
+	}
+	// an extended length of 1<<63: an int object of 8 bytes (0x13)
+	hugeLength := []byte{0x13, 0x80, 0, 0, 0, 0, 0, 0, 0}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "offset table offset overflows", data: withTrailer(24, math.MaxUint64-1)},
+		{name: "offset table past the end", data: withTrailer(24, uint64(len(valid)))},
+		{name: "too many objects", data: withTrailer(8, math.MaxUint64/2+1)},
+		{name: "top object out of range", data: withTrailer(16, 1)},
+		{name: "utf-16 string length overflows", data: buildBinaryPlist([][]byte{append([]byte{0x6f}, hugeLength...)})},
+		{name: "array length overflows", data: buildBinaryPlist([][]byte{append([]byte{0xaf}, hugeLength...)})},
+		{name: "dict length overflows", data: buildBinaryPlist([][]byte{append([]byte{0xdf}, hugeLength...)})},
+		{name: "string longer than the data", data: buildBinaryPlist([][]byte{{0x5f, 0x10, 0xff, 'a'}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.data); err == nil {
+				t.Error("Decode() error = nil")
+			}
+		})
+	}
+}
+
+func TestEncodeXML(t *testing.T) {
+	value, err := Decode([]byte(testXMLPlist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value.(map[string]interface{})["Escaped"] = "<a & b>"
+
+	data, err := EncodeXML(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() of the encoded plist error = %s\n%s", err, data)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("Decode(EncodeXML()) = %#v, want %#v", got, value)
+	}
+
+	if _, err := EncodeXML(map[string]interface{}{"a": 1}); err == nil {
+		t.Error("EncodeXML() of an int error = nil")
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/bitrise-step-flank/plist"
+)
+
+const (
+	quarantinedXCTestRunFileName = "quarantined.xctestrun"
+	packagePatternSuffix         = ".*"
+	skipTestIdentifiersKey       = "SkipTestIdentifiers"
+)
+
+// quarantineEntry is a line of the quarantine file:
+// a test (com.example.Class#method), a class (com.example.Class) or a package pattern (com.example.*),
+// iOS entries may use / as separator and may be prefixed by the test target (MyAppTests/Class/method)
+type quarantineEntry struct {
+	Target  string
+	Class   string
+	Method  string
+	Package string
+}
+
+func (e quarantineEntry) String() string {
+	switch {
+	case e.Package != "":
+		return e.Package + packagePatternSuffix
+	case e.Method != "":
+		return e.Class + "#" + e.Method
+	default:
+		return e.Class
+	}
+}
+
+func parseQuarantineEntry(line string) (quarantineEntry, error) {
+	if strings.HasSuffix(line, packagePatternSuffix) {
+		return quarantineEntry{Package: strings.TrimSuffix(line, packagePatternSuffix)}, nil
+	}
+
+	parts := strings.Split(strings.Replace(line, "#", "/", 1), "/")
+	for _, part := range parts {
+		if part == "" || strings.ContainsAny(part, " \t") {
+			return quarantineEntry{}, fmt.Errorf("invalid quarantine entry: %s", line)
+		}
+	}
+
+	switch len(parts) {
+	case 1:
+		return quarantineEntry{Class: parts[0]}, nil
+	case 2:
+		// Class#method and Class/method are the same, Target/Class is told apart on iOS by the target names
+		return quarantineEntry{Class: parts[0], Method: parts[1]}, nil
+	case 3:
+		return quarantineEntry{Target: parts[0], Class: parts[1], Method: parts[2]}, nil
+	default:
+		return quarantineEntry{}, fmt.Errorf("invalid quarantine entry: %s", line)
+	}
+}
+
+// parses the quarantine file, one entry per line, empty lines and # comments are skipped
+func parseQuarantine(r io.Reader) ([]quarantineEntry, error) {
+	var entries []quarantineEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseQuarantineEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func readQuarantineFile(pth string) ([]quarantineEntry, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file, error: %s", err)
+		}
+	}()
+	return parseQuarantine(f)
+}
+
+// reports whether the test case is quarantined, the class name of iOS test cases may be prefixed by the test target
+func (e quarantineEntry) matches(className, name string) bool {
+	if e.Package != "" {
+		return strings.HasPrefix(className, e.Package+".")
+	}
+	if className != e.Class && !strings.HasSuffix(className, "."+e.Class) {
+		return false
+	}
+	return e.Method == "" || e.Method == name
+}
+
+func isQuarantined(entries []quarantineEntry, className, name string) bool {
+	for _, entry := range entries {
+		if entry.matches(className, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the android test-targets excluding the quarantined tests: a notClass entry of the tests and classes
+// and a notPackage entry of the package patterns
+func quarantineTestTargets(entries []quarantineEntry) []string {
+	var classes, packages []string
+	for _, entry := range entries {
+		if entry.Package != "" {
+			packages = append(packages, entry.Package)
+		} else {
+			classes = append(classes, entry.String())
+		}
+	}
+
+	var targets []string
+	if len(classes) > 0 {
+		targets = append(targets, "notClass "+strings.Join(classes, ","))
+	}
+	if len(packages) > 0 {
+		targets = append(targets, "notPackage "+strings.Join(packages, ","))
+	}
+	return targets
+}
+
+// returns the test-targets of the config extended by the entries, the entries already in the config are not repeated
+func appendTestTargets(value interface{}, entries []string) []interface{} {
+	var targets []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		targets = append(targets, v...)
+	case string:
+		targets = append(targets, v)
+	}
+
+	existing := map[string]bool{}
+	for _, target := range targets {
+		if s, ok := target.(string); ok {
+			existing[s] = true
+		}
+	}
+	for _, entry := range entries {
+		if !existing[entry] {
+			existing[entry] = true
+			targets = append(targets, entry)
+		}
+	}
+	return targets
+}
+
+// returns the skip-testing identifiers (Class or Class/method) of the quarantine entries for the test target,
+// entries of other targets and package patterns are left out
+func quarantineSkipIdentifiers(entries []quarantineEntry, target string, targets map[string]bool) []string {
+	var identifiers []string
+	for _, entry := range entries {
+		switch {
+		case entry.Package != "":
+			continue
+		case entry.Target != "":
+			if entry.Target == target {
+				identifiers = append(identifiers, entry.Class+"/"+entry.Method)
+			}
+		case entry.Method != "" && targets[entry.Class]:
+			// Target/Class entry
+			if entry.Class == target {
+				identifiers = append(identifiers, entry.Method)
+			}
+		case entry.Method != "":
+			identifiers = append(identifiers, entry.Class+"/"+entry.Method)
+		default:
+			identifiers = append(identifiers, entry.Class)
+		}
+	}
+	return identifiers
+}
+
+// adds the quarantined tests to the SkipTestIdentifiers of the xctestrun targets as xcodebuild -skip-testing does,
+// returns the number of identifiers added
+func skipQuarantinedTests(root map[string]interface{}, entries []quarantineEntry) int {
+	var targetDicts []map[string]interface{}
+	var targetNames []string
+	if configurations, ok := root["TestConfigurations"].([]interface{}); ok {
+		for _, c := range configurations {
+			configuration, _ := c.(map[string]interface{})
+			targets, _ := configuration["TestTargets"].([]interface{})
+			for _, t := range targets {
+				if target, ok := t.(map[string]interface{}); ok {
+					targetDicts = append(targetDicts, target)
+					targetNames = append(targetNames, plistString(target, "BlueprintName"))
+				}
+			}
+		}
+	} else {
+		var names []string
+		for name := range root {
+			if name != xctestrunMetadataKey {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if target, ok := root[name].(map[string]interface{}); ok {
+				targetDicts = append(targetDicts, target)
+				targetNames = append(targetNames, name)
+			}
+		}
+	}
+
+	targets := map[string]bool{}
+	for _, name := range targetNames {
+		targets[name] = true
+	}
+
+	var added int
+	for i, target := range targetDicts {
+		identifiers := quarantineSkipIdentifiers(entries, targetNames[i], targets)
+		if len(identifiers) == 0 {
+			continue
+		}
+		skip, _ := target[skipTestIdentifiersKey].([]interface{})
+		for _, identifier := range identifiers {
+			skip = append(skip, identifier)
+		}
+		target[skipTestIdentifiersKey] = skip
+		added += len(identifiers)
+	}
+	return added
+}
+
+// writes a copy of the xctestrun file with the quarantined tests skipped, returns the number of identifiers added
+func writeQuarantinedXCTestRun(xctestrunPath, pth string, entries []quarantineEntry) (int, error) {
+	data, err := ioutil.ReadFile(xctestrunPath)
+	if err != nil {
+		return 0, err
+	}
+	value, err := plist.Decode(data)
+	if err != nil {
+		return 0, err
+	}
+	root, ok := value.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("xctestrun root is not a dict")
+	}
+
+	added := skipQuarantinedTests(root, entries)
+	if data, err = plist.EncodeXML(root); err != nil {
+		return 0, err
+	}
+	return added, ioutil.WriteFile(pth, data, 0600)
+}
+
+// quarantineReport lists the quarantined tests which ran
+type quarantineReport struct {
+	Passed []string `json:"passed"`
+	Failed []string `json:"failed"`
+}
+
+// collects the quarantined test cases of the results and returns the results without them,
+// a quarantined test counts as failed if it failed on any device
+func splitQuarantined(suites junitTestSuites, entries []quarantineEntry) (quarantineReport, junitTestSuites) {
+	failed := map[string]bool{}
+	rest := junitTestSuites{XMLName: suites.XMLName}
+	for _, suite := range suites.Suites {
+		kept := suite
+		kept.TestCases = nil
+		for _, tc := range suite.TestCases {
+			if !isQuarantined(entries, tc.ClassName, tc.Name) {
+				kept.TestCases = append(kept.TestCases, tc)
+				continue
+			}
+			if tc.skipped() {
+				continue
+			}
+			name := tc.ClassName + "#" + tc.Name
+			failed[name] = failed[name] || tc.failed() || tc.errored()
+		}
+		// the suite time is kept only if no test case was removed
+		if len(kept.TestCases) != len(suite.TestCases) {
+			kept.Time = ""
+		}
+		rest.Suites = append(rest.Suites, kept)
+	}
+
+	report := quarantineReport{Passed: []string{}, Failed: []string{}}
+	for name, f := range failed {
+		if f {
+			report.Failed = append(report.Failed, name)
+		} else {
+			report.Passed = append(report.Passed, name)
+		}
+	}
+	sort.Strings(report.Passed)
+	sort.Strings(report.Failed)
+	return report, rest
+}
+
+// returns the classification the exit code policy is applied to: a test failure caused only by quarantined tests counts as success,
+// rest is the summary of the results without the quarantined tests
+func releaseQuarantinedFailures(classification exitClassification, quarantined quarantineReport, rest testSummary) (exitClassification, bool) {
+	if classification.Category != exitCategoryTestFailure || len(quarantined.Failed) == 0 || rest.Failed > 0 || rest.Errors > 0 {
+		return classification, false
+	}
+	return exitClassification{Status: 0, Category: exitCategorySuccess, Description: "only quarantined tests failed"}, true
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const testQuarantineFile = `# known flaky tests
+com.example.MainTest#testFail
+
+com.example.OtherTest
+com.example.legacy.*
+`
+
+func Test_parseQuarantine(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []quarantineEntry
+		wantErr bool
+	}{
+		{
+			name:    "android entries",
+			content: testQuarantineFile,
+			want: []quarantineEntry{
+				{Class: "com.example.MainTest", Method: "testFail"},
+				{Class: "com.example.OtherTest"},
+				{Package: "com.example.legacy"},
+			},
+		},
+		{
+			name:    "ios entries",
+			content: "MyAppTests/LoginTests/testLogin\nLoginTests/testLogout\n",
+			want: []quarantineEntry{
+				{Target: "MyAppTests", Class: "LoginTests", Method: "testLogin"},
+				{Class: "LoginTests", Method: "testLogout"},
+			},
+		},
+		{
+			name:    "invalid entry",
+			content: "com.example.MainTest#",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQuarantine(strings.NewReader(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseQuarantine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseQuarantine() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_quarantineTestTargets(t *testing.T) {
+	entries, err := parseQuarantine(strings.NewReader(testQuarantineFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets := quarantineTestTargets(entries)
+	want := []string{"notClass com.example.MainTest#testFail,com.example.OtherTest", "notPackage com.example.legacy"}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("quarantineTestTargets() = %v, want %v", targets, want)
+	}
+
+	got := appendTestTargets([]interface{}{"annotation androidx.test.filters.LargeTest"}, targets)
+	wantTargets := []interface{}{"annotation androidx.test.filters.LargeTest", want[0], want[1]}
+	if !reflect.DeepEqual(got, wantTargets) {
+		t.Errorf("appendTestTargets() = %v, want %v", got, wantTargets)
+	}
+}
+
+func Test_skipQuarantinedTests(t *testing.T) {
+	root := map[string]interface{}{
+		"TestConfigurations": []interface{}{
+			map[string]interface{}{
+				"Name": "English",
+				"TestTargets": []interface{}{
+					map[string]interface{}{"BlueprintName": "MyAppTests", skipTestIdentifiersKey: []interface{}{"SlowTests"}},
+					map[string]interface{}{"BlueprintName": "MyAppUITests"},
+				},
+			},
+		},
+	}
+	entries := []quarantineEntry{
+		{Target: "MyAppTests", Class: "LoginTests", Method: "testLogin"},
+		{Class: "MyAppUITests", Method: "LaunchTests"},
+		{Class: "FlakyTests"},
+		{Package: "com.example"},
+	}
+
+	if added := skipQuarantinedTests(root, entries); added != 4 {
+		t.Errorf("skipQuarantinedTests() = %d, want 4", added)
+	}
+	targets := root["TestConfigurations"].([]interface{})[0].(map[string]interface{})["TestTargets"].([]interface{})
+	if got, want := targets[0].(map[string]interface{})[skipTestIdentifiersKey], []interface{}{"SlowTests", "LoginTests/testLogin", "FlakyTests"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MyAppTests %s = %v, want %v", skipTestIdentifiersKey, got, want)
+	}
+	if got, want := targets[1].(map[string]interface{})[skipTestIdentifiersKey], []interface{}{"LaunchTests", "FlakyTests"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MyAppUITests %s = %v, want %v", skipTestIdentifiersKey, got, want)
+	}
+}
+
+func Test_splitQuarantined(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites, err := parseJUnitReport(writeTestFile(t, tmpDir, junitReportFileName, testJUnitReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []quarantineEntry{{Class: "com.example.MainTest", Method: "testFail"}, {Class: "com.example.MainTest", Method: "testPass"}}
+	report, rest := splitQuarantined(suites, entries)
+	want := quarantineReport{Passed: []string{"com.example.MainTest#testPass"}, Failed: []string{"com.example.MainTest#testFail"}}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("splitQuarantined() report = %+v, want %+v", report, want)
+	}
+
+	summary := summarizeTests(rest)
+	if summary.Total != 3 || summary.Failed != 0 || summary.Errors != 1 {
+		t.Errorf("summary of the rest = %+v, want 3 tests with 1 error", summary)
+	}
+
+	testFailure := classifyExitStatus("v20.08.0", 10, nil)
+	if _, released := releaseQuarantinedFailures(testFailure, report, summary); released {
+		t.Error("releaseQuarantinedFailures() released a run with an unquarantined error")
+	}
+	summary.Errors = 0
+	got, released := releaseQuarantinedFailures(testFailure, report, summary)
+	if !released || got.Category != exitCategorySuccess || got.Status != 0 {
+		t.Errorf("releaseQuarantinedFailures() = %+v, %v, want success", got, released)
+	}
+}
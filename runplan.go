@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// flank's defaults of the matrix size settings
+	defaultShardTimeout = 15 * time.Minute
+	// max-test-shards: -1 makes flank use as many shards as Firebase Test Lab allows
+	maxTestShardsAuto  = -1
+	maxTestShardsLimit = 50
+)
+
+// runPlan is the planned size of the flank run
+type runPlan struct {
+	Devices       int
+	Shards        int
+	TestRuns      int
+	FlakyAttempts int
+	// ShardTimeout is the timeout of a single test execution
+	ShardTimeout time.Duration
+	// ShardMinutes is the sum of the planned shard durations on a single device in a single test run
+	ShardMinutes float64
+	// Estimated is true if ShardMinutes is estimated from the dumped shards, otherwise every shard is expected to reach the timeout
+	Estimated bool
+}
+
+// returns the number of test executions, every shard runs on every device in every test run and may be retried for flaky tests
+func (p runPlan) executions() int {
+	return p.Devices * p.Shards * p.TestRuns * (1 + p.FlakyAttempts)
+}
+
+// returns the upper bound of the device minutes of the run
+func (p runPlan) deviceMinutes() float64 {
+	return float64(p.Devices*p.TestRuns*(1+p.FlakyAttempts)) * p.ShardMinutes
+}
+
+func (p runPlan) breakdown() string {
+	shardMinutes := fmt.Sprintf("%s timeout per shard", p.ShardTimeout)
+	if p.Estimated {
+		shardMinutes = fmt.Sprintf("%.0f estimated shard minutes", p.ShardMinutes)
+	}
+	return fmt.Sprintf("%d devices × %d shards × %d test runs × %d attempts = %d test executions, %s = %.0f device minutes",
+		p.Devices, p.Shards, p.TestRuns, 1+p.FlakyAttempts, p.executions(), shardMinutes, p.deviceMinutes())
+}
+
+// parses gcloud's duration format (eg.: 90s, 15m, 1h) or a plain number of seconds
+func parseShardTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
+
+// returns the planned size of the run based on the config and the command flags overriding it,
+// shards is nil if the shards were not dumped, in that case the run is planned with max-test-shards
+func planRun(cfg flankConfig, commandFlags []string, shards []shard, times shardTimes) (runPlan, error) {
+	plan := runPlan{
+		Devices:       len(cfg.Gcloud.Device),
+		TestRuns:      cfg.Flank.NumTestRuns,
+		FlakyAttempts: cfg.Gcloud.NumFlakyTestAttempts,
+		ShardTimeout:  defaultShardTimeout,
+	}
+	if devices := flagValues(commandFlags, "--device"); len(devices) > 0 {
+		plan.Devices = len(devices)
+	}
+	if plan.Devices == 0 {
+		// flank runs on its default device
+		plan.Devices = 1
+	}
+
+	if value := flagValue(commandFlags, "--num-test-runs"); value != "" {
+		runs, err := strconv.Atoi(value)
+		if err != nil {
+			return runPlan{}, fmt.Errorf("invalid --num-test-runs (%s), error: %s", value, err)
+		}
+		plan.TestRuns = runs
+	}
+	if plan.TestRuns < 1 {
+		plan.TestRuns = 1
+	}
+
+	if value := flagValue(commandFlags, "--num-flaky-test-attempts"); value != "" {
+		attempts, err := strconv.Atoi(value)
+		if err != nil {
+			return runPlan{}, fmt.Errorf("invalid --num-flaky-test-attempts (%s), error: %s", value, err)
+		}
+		plan.FlakyAttempts = attempts
+	}
+	if plan.FlakyAttempts < 0 {
+		plan.FlakyAttempts = 0
+	}
+
+	timeout := cfg.Gcloud.Timeout
+	if value := flagValue(commandFlags, "--timeout"); value != "" {
+		timeout = value
+	}
+	if timeout != "" {
+		d, err := parseShardTimeout(timeout)
+		if err != nil {
+			return runPlan{}, fmt.Errorf("invalid timeout (%s), error: %s", timeout, err)
+		}
+		plan.ShardTimeout = d
+	}
+
+	if shards != nil {
+		plan.Shards = len(shards)
+		plan.Estimated = true
+		for _, s := range shards {
+			plan.ShardMinutes += math.Min(s.estimatedSeconds(times), plan.ShardTimeout.Seconds()) / 60
+		}
+		return plan, nil
+	}
+
+	plan.Shards = cfg.Flank.MaxTestShards
+	if value := flagValue(commandFlags, "--max-test-shards"); value != "" {
+		maxShards, err := strconv.Atoi(value)
+		if err != nil {
+			return runPlan{}, fmt.Errorf("invalid --max-test-shards (%s), error: %s", value, err)
+		}
+		plan.Shards = maxShards
+	}
+	switch {
+	case plan.Shards == maxTestShardsAuto:
+		plan.Shards = maxTestShardsLimit
+	case plan.Shards < 1:
+		plan.Shards = 1
+	}
+	plan.ShardMinutes = float64(plan.Shards) * plan.ShardTimeout.Minutes()
+	return plan, nil
+}
+
+// returns an error with the breakdown of the plan if it exceeds one of the limits, a 0 limit is disabled
+func checkRunPlan(plan runPlan, maxDeviceMinutes, maxTestExecutions int) error {
+	var exceeded []string
+	if maxTestExecutions > 0 && plan.executions() > maxTestExecutions {
+		exceeded = append(exceeded, fmt.Sprintf("%d test executions exceed max_test_executions (%d)", plan.executions(), maxTestExecutions))
+	}
+	if maxDeviceMinutes > 0 && plan.deviceMinutes() > float64(maxDeviceMinutes) {
+		exceeded = append(exceeded, fmt.Sprintf("%.0f device minutes exceed max_device_minutes (%d)", plan.deviceMinutes(), maxDeviceMinutes))
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", strings.Join(exceeded, ", "), plan.breakdown())
+}
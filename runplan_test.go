@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func Test_planRun(t *testing.T) {
+	var cfg flankConfig
+	if err := yaml.Unmarshal([]byte(`gcloud:
+  timeout: 600
+  num-flaky-test-attempts: 1
+  device:
+  - model: NexusLowRes
+    version: 28
+  - model: Pixel2
+    version: 29
+flank:
+  max-test-shards: 4
+  num-test-runs: 2
+`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name              string
+		cfg               flankConfig
+		commandFlags      []string
+		shards            []shard
+		wantExecutions    int
+		wantDeviceMinutes float64
+	}{
+		{name: "defaults", wantExecutions: 1, wantDeviceMinutes: 15},
+		{name: "config", cfg: cfg, wantExecutions: 2 * 4 * 2 * 2, wantDeviceMinutes: 2 * 2 * 2 * 4 * 10},
+		{
+			name:              "flags override config",
+			cfg:               cfg,
+			commandFlags:      []string{"--device", "model=Pixel2,version=29", "--max-test-shards=-1", "--num-test-runs=1", "--timeout=2m"},
+			wantExecutions:    50 * 2,
+			wantDeviceMinutes: 2 * 50 * 2,
+		},
+		{
+			name:           "dumped shards",
+			cfg:            cfg,
+			shards:         []shard{{Tests: []string{"a", "b"}}, {Tests: strings.Split("c,d,e,f,g,h,i,j,k,l,m,n", ",")}},
+			wantExecutions: 2 * 2 * 2 * 2,
+			// the second shard's 12 minutes are capped at the 10 minutes timeout
+			wantDeviceMinutes: 2 * 2 * 2 * (2 + 10),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planRun(tt.cfg, tt.commandFlags, tt.shards, shardTimes{TestTime: 60, ClassTestTime: 120})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.executions() != tt.wantExecutions {
+				t.Errorf("executions() = %d, want %d", got.executions(), tt.wantExecutions)
+			}
+			if got.deviceMinutes() != tt.wantDeviceMinutes {
+				t.Errorf("deviceMinutes() = %v, want %v", got.deviceMinutes(), tt.wantDeviceMinutes)
+			}
+		})
+	}
+}
+
+func Test_checkRunPlan(t *testing.T) {
+	plan := runPlan{Devices: 6, Shards: 50, TestRuns: 1, ShardTimeout: 15 * time.Minute, ShardMinutes: 50 * 15}
+
+	if err := checkRunPlan(plan, 0, 0); err != nil {
+		t.Errorf("checkRunPlan() without limits error = %v", err)
+	}
+	if err := checkRunPlan(plan, 5000, 300); err != nil {
+		t.Errorf("checkRunPlan() within limits error = %v", err)
+	}
+
+	err := checkRunPlan(plan, 1000, 100)
+	if err == nil {
+		t.Fatal("checkRunPlan() over budget error = nil")
+	}
+	for _, want := range []string{"300 test executions exceed max_test_executions (100)", "4500 device minutes exceed max_device_minutes (1000)", "6 devices × 50 shards × 1 test runs × 1 attempts"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("checkRunPlan() error = %s, does not contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+const (
+	dumpShardsFlag = "--dump-shards"
+	// flank's default durations of a test and a test class without timing data
+	defaultTestTime      = 120.0
+	defaultClassTestTime = 240.0
+)
+
+// shard is a group of tests flank runs on a single device
+type shard struct {
+	Matrix string
+	Index  int
+	Tests  []string
+}
+
+// the android_shards.json written by newer flank versions, matrices are keyed by matrix-N
+type dumpedMatrix struct {
+	Shards map[string][]string `json:"shards"`
+}
+
+// returns the file flank writes the shards into when called with --dump-shards
+func shardsFileName(platform string) string {
+	return platform + "_shards.json"
+}
+
+// returns the trailing index of the given key (eg.: shard-3), -1 if there is no index
+func keyIndex(key string) int {
+	idx := strings.LastIndex(key, "-")
+	if idx == -1 {
+		return -1
+	}
+	i, err := strconv.Atoi(key[idx+1:])
+	if err != nil {
+		return -1
+	}
+	return i
+}
+
+// sorts the keys by their trailing index, so that shard-10 comes after shard-9
+func sortKeysByIndex(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		if ki, kj := keyIndex(keys[i]), keyIndex(keys[j]); ki != kj {
+			return ki < kj
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// parses the shards file written by flank's --dump-shards, it is either a list of shards (older flank versions and iOS)
+// or an object of matrices with their shards
+func parseShards(data []byte) ([]shard, error) {
+	var list [][]string
+	if err := json.Unmarshal(data, &list); err == nil {
+		var shards []shard
+		for i, tests := range list {
+			shards = append(shards, shard{Index: i, Tests: tests})
+		}
+		return shards, nil
+	}
+
+	var matrices map[string]json.RawMessage
+	if err := json.Unmarshal(data, &matrices); err != nil {
+		return nil, fmt.Errorf("unknown shards format, error: %s", err)
+	}
+
+	var names []string
+	for name := range matrices {
+		names = append(names, name)
+	}
+	sortKeysByIndex(names)
+
+	var shards []shard
+	for _, name := range names {
+		if err := json.Unmarshal(matrices[name], &list); err == nil {
+			for i, tests := range list {
+				shards = append(shards, shard{Matrix: name, Index: i, Tests: tests})
+			}
+			continue
+		}
+
+		var matrix dumpedMatrix
+		if err := json.Unmarshal(matrices[name], &matrix); err != nil {
+			return nil, fmt.Errorf("unknown shards format of %s, error: %s", name, err)
+		}
+
+		var shardNames []string
+		for shardName := range matrix.Shards {
+			shardNames = append(shardNames, shardName)
+		}
+		sortKeysByIndex(shardNames)
+
+		for i, shardName := range shardNames {
+			shards = append(shards, shard{Matrix: name, Index: i, Tests: matrix.Shards[shardName]})
+		}
+	}
+	return shards, nil
+}
+
+func readShards(pth string) ([]shard, error) {
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	return parseShards(data)
+}
+
+// removes the shards file left by a previous --dump-shards call, so that a stale file is not reported
+func removeShardsFile(pth string) error {
+	if err := os.Remove(pth); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// shardTimes are the durations the shard durations are estimated with
+type shardTimes struct {
+	TestTime      float64
+	ClassTestTime float64
+	// Timings are the historical durations of the tests by shard entry (eg.: class com.Foo#test), nil without timing data
+	Timings map[string]float64
+}
+
+// returns the estimated duration of a shard entry, android entries without a method (class com.Foo) run a whole class
+func (t shardTimes) testSeconds(test string) float64 {
+	if seconds, ok := t.Timings[test]; ok {
+		return seconds
+	}
+	if !strings.HasPrefix(test, "class ") || strings.Contains(test, "#") {
+		return t.TestTime
+	}
+
+	var seconds float64
+	var found bool
+	for name, timing := range t.Timings {
+		if strings.HasPrefix(name, test+"#") {
+			seconds += timing
+			found = true
+		}
+	}
+	if !found {
+		return t.ClassTestTime
+	}
+	return seconds
+}
+
+// returns the estimated duration of the shard in seconds
+func (s shard) estimatedSeconds(times shardTimes) float64 {
+	var seconds float64
+	for _, test := range s.Tests {
+		seconds += times.testSeconds(test)
+	}
+	return seconds
+}
+
+// returns the default test and class test times used for the estimation, command flags override the config values
+func shardTimeSettings(cfg flankConfig, commandFlags []string) shardTimes {
+	times := shardTimes{TestTime: defaultTestTime, ClassTestTime: defaultClassTestTime}
+	if cfg.Flank.DefaultTestTime > 0 {
+		times.TestTime = cfg.Flank.DefaultTestTime
+	}
+	if cfg.Flank.DefaultClassTestTime > 0 {
+		times.ClassTestTime = cfg.Flank.DefaultClassTestTime
+	}
+	if value, err := strconv.ParseFloat(flagValue(commandFlags, "--default-test-time"), 64); err == nil && value > 0 {
+		times.TestTime = value
+	}
+	if value, err := strconv.ParseFloat(flagValue(commandFlags, "--default-class-test-time"), 64); err == nil && value > 0 {
+		times.ClassTestTime = value
+	}
+	return times
+}
+
+func printShards(w io.Writer, shards []shard, times shardTimes) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintln(tw, " Matrix\t Shard\t Tests\t Estimated time\t")
+	for _, s := range shards {
+		matrix := s.Matrix
+		if matrix == "" {
+			matrix = "-"
+		}
+		fmt.Fprintf(tw, " %s\t %d\t %d\t %.0fs\t\n", matrix, s.Index, len(s.Tests), s.estimatedSeconds(times))
+	}
+	return tw.Flush()
+}
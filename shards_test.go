@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseShards(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []shard
+		wantErr bool
+	}{
+		{
+			name: "list of shards",
+			data: `[["EarlGreyExampleTests/testA","EarlGreyExampleTests/testB"],["EarlGreyExampleTests/testC"]]`,
+			want: []shard{
+				{Index: 0, Tests: []string{"EarlGreyExampleTests/testA", "EarlGreyExampleTests/testB"}},
+				{Index: 1, Tests: []string{"EarlGreyExampleTests/testC"}},
+			},
+		},
+		{
+			name: "matrices",
+			data: `{
+  "matrix-10": {"app": "app.apk", "test": "test2.apk", "shards": {"shard-0": ["class com.example.OtherTest"]}},
+  "matrix-2": {
+    "app": "app.apk",
+    "test": "test.apk",
+    "shards": {
+      "shard-10": ["class com.example.MainTest#testK"],
+      "shard-9": ["class com.example.MainTest#testA", "class com.example.MainTest#testB"]
+    },
+    "junit-ignored": []
+  }
+}`,
+			want: []shard{
+				{Matrix: "matrix-2", Index: 0, Tests: []string{"class com.example.MainTest#testA", "class com.example.MainTest#testB"}},
+				{Matrix: "matrix-2", Index: 1, Tests: []string{"class com.example.MainTest#testK"}},
+				{Matrix: "matrix-10", Index: 0, Tests: []string{"class com.example.OtherTest"}},
+			},
+		},
+		{name: "invalid", data: `"shards"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseShards([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseShards() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseShards() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_shard_estimatedSeconds(t *testing.T) {
+	s := shard{Tests: []string{"class com.example.MainTest#testA", "class com.example.OtherTest", "class com.example.TimedTest", "EarlGreyExampleTests/testC"}}
+	times := shardTimes{
+		TestTime:      10,
+		ClassTestTime: 100,
+		Timings: map[string]float64{
+			"class com.example.MainTest#testA":  3,
+			"class com.example.TimedTest#testA": 1.5,
+			"class com.example.TimedTest#testB": 2.5,
+		},
+	}
+	if got := s.estimatedSeconds(times); got != 3+100+4+10 {
+		t.Errorf("estimatedSeconds() = %v, want 117", got)
+	}
+}
+
+func Test_shardTimeSettings(t *testing.T) {
+	var cfg flankConfig
+	cfg.Flank.DefaultTestTime = 30
+
+	if got := shardTimeSettings(cfg, []string{"--default-class-test-time=90"}); got.TestTime != 30 || got.ClassTestTime != 90 {
+		t.Errorf("shardTimeSettings() = %+v, want 30, 90", got)
+	}
+	if got := shardTimeSettings(flankConfig{}, nil); got.TestTime != defaultTestTime || got.ClassTestTime != defaultClassTestTime {
+		t.Errorf("shardTimeSettings() = %+v, want defaults", got)
+	}
+}
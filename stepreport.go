@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	stepReportFileName = "flank-step-report.json"
+	// stepReportSchemaVersion has to be increased on every incompatible change of the report,
+	// adding new fields is not considered incompatible
+	stepReportSchemaVersion = 1
+	redactedValue           = "[REDACTED]"
+)
+
+var sensitiveFlagPattern = regexp.MustCompile(`(?i)(token|secret|password|passwd|credential|auth|key)`)
+
+// stepReport is the machine readable summary of a step run, written to flank-step-report.json
+type stepReport struct {
+	SchemaVersion int               `json:"schema_version"`
+	FlankVersion  string            `json:"flank_version"`
+	Platform      string            `json:"platform"`
+	ConfigHash    string            `json:"config_hash"`
+	Command       []string          `json:"command"`
+	Phases        stepReportPhases  `json:"phases"`
+	Exit          stepReportExit    `json:"exit"`
+	Tests         *stepReportTests  `json:"tests"`
+	Quarantined   *quarantineReport `json:"quarantined,omitempty"`
+	History       *historyReport    `json:"history,omitempty"`
+	Baseline      *baselineDiff     `json:"baseline,omitempty"`
+	Durations     *durationReport   `json:"durations,omitempty"`
+	Cost          *costReport       `json:"cost"`
+	MatrixIDs     []string          `json:"matrix_ids"`
+	ExportedFiles []string          `json:"exported_files"`
+}
+
+type stepReportPhases struct {
+	DownloadSeconds float64 `json:"download_seconds"`
+	RunSeconds      float64 `json:"run_seconds"`
+	ExportSeconds   float64 `json:"export_seconds"`
+}
+
+type stepReportExit struct {
+	Status      int          `json:"status"`
+	Category    exitCategory `json:"category"`
+	Description string       `json:"description,omitempty"`
+	FlankError  string       `json:"flank_error,omitempty"`
+	Action      exitAction   `json:"action"`
+}
+
+type stepReportTests struct {
+	Total       int      `json:"total"`
+	Passed      int      `json:"passed"`
+	Failed      int      `json:"failed"`
+	Errors      int      `json:"errors"`
+	Skipped     int      `json:"skipped"`
+	Flaky       int      `json:"flaky"`
+	TimeSeconds float64  `json:"time_seconds"`
+	FailedTests []string `json:"failed_tests"`
+	// Cases are the results of the tests merged across the devices, sorted by name
+	Cases []stepReportTestCase `json:"cases"`
+}
+
+type stepReportTestCase struct {
+	Name        string  `json:"name"`
+	Status      string  `json:"status"`
+	TimeSeconds float64 `json:"time_seconds"`
+}
+
+// phaseTimer measures the duration of the step phases
+type phaseTimer struct {
+	start time.Time
+}
+
+func startPhase() phaseTimer {
+	return phaseTimer{start: time.Now()}
+}
+
+func (p phaseTimer) seconds() float64 {
+	return time.Since(p.start).Seconds()
+}
+
+// returns the hex encoded sha256 hash of the file content
+func fileSHA256(pth string) (string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file, error: %s", err)
+		}
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replaces the value of the flags which look like holding a secret (eg.: --token=abc or --token abc)
+func redactCommandArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+
+	for i := 0; i < len(redacted); i++ {
+		arg := redacted[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if idx := strings.Index(name, "="); idx != -1 {
+			if sensitiveFlagPattern.MatchString(name[:idx]) {
+				redacted[i] = arg[:strings.Index(arg, "=")+1] + redactedValue
+			}
+			continue
+		}
+
+		if sensitiveFlagPattern.MatchString(name) && i+1 < len(redacted) && !strings.HasPrefix(redacted[i+1], "-") {
+			redacted[i+1] = redactedValue
+			i++
+		}
+	}
+	return redacted
+}
+
+// returns the sorted, unique class#name list of the failed and errored test cases
+func failedTestNames(suites junitTestSuites) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, suite := range suites.Suites {
+		for _, tc := range suite.TestCases {
+			if !tc.failed() && !tc.errored() {
+				continue
+			}
+			name := tc.ClassName + "#" + tc.Name
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newStepReportTests(suites junitTestSuites) *stepReportTests {
+	summary := summarizeTests(suites)
+	failed := failedTestNames(suites)
+	if failed == nil {
+		failed = []string{}
+	}
+	return &stepReportTests{
+		Total:       summary.Total,
+		Passed:      summary.Passed,
+		Failed:      summary.Failed,
+		Errors:      summary.Errors,
+		Skipped:     summary.Skipped,
+		Flaky:       summary.Flaky,
+		TimeSeconds: summary.Time,
+		FailedTests: failed,
+		Cases:       stepReportTestCases(collectTestRunResults(suites)),
+	}
+}
+
+func stepReportTestCases(results map[string]testRunResult) []stepReportTestCase {
+	cases := []stepReportTestCase{}
+	for name, result := range results {
+		cases = append(cases, stepReportTestCase{Name: name, Status: result.Status, TimeSeconds: result.Seconds})
+	}
+	sort.Slice(cases, func(i, j int) bool { return cases[i].Name < cases[j].Name })
+	return cases
+}
+
+func writeStepReport(pth string, report stepReport) error {
+	report.SchemaVersion = stepReportSchemaVersion
+	if report.MatrixIDs == nil {
+		report.MatrixIDs = []string{}
+	}
+	if report.ExportedFiles == nil {
+		report.ExportedFiles = []string{}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, data)
+}
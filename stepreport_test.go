@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_redactCommandArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "no secrets",
+			args: []string{"java", "-jar", "flank.jar", "android", "run", "-c", "flank.yml", "--dry"},
+			want: []string{"java", "-jar", "flank.jar", "android", "run", "-c", "flank.yml", "--dry"},
+		},
+		{
+			name: "flag with equal sign",
+			args: []string{"run", "--api-token=abc", "--dry"},
+			want: []string{"run", "--api-token=[REDACTED]", "--dry"},
+		},
+		{
+			name: "flag with separate value",
+			args: []string{"run", "--client-secret", "abc", "--dry"},
+			want: []string{"run", "--client-secret", "[REDACTED]", "--dry"},
+		},
+		{
+			name: "sensitive switch without value",
+			args: []string{"run", "--use-auth", "--dry"},
+			want: []string{"run", "--use-auth", "--dry"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactCommandArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("redactCommandArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeStepReport(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-step-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites, err := parseJUnitReport(writeTestFile(t, tmpDir, junitReportFileName, testJUnitReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pth := filepath.Join(tmpDir, stepReportFileName)
+	if err := writeStepReport(pth, stepReport{
+		FlankVersion: "v20.05.2",
+		Platform:     platformAndroid,
+		Exit:         stepReportExit{Status: 10},
+		Tests:        newStepReportTests(suites),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got["schema_version"] != float64(stepReportSchemaVersion) {
+		t.Errorf("schema_version = %v", got["schema_version"])
+	}
+	if !reflect.DeepEqual(got["matrix_ids"], []interface{}{}) {
+		t.Errorf("matrix_ids = %v, want empty list", got["matrix_ids"])
+	}
+	failed := got["tests"].(map[string]interface{})["failed_tests"]
+	if !reflect.DeepEqual(failed, []interface{}{"com.example.MainTest#testFail", "com.example.OtherTest#testCrash"}) {
+		t.Errorf("failed_tests = %v", failed)
+	}
+	cases := got["tests"].(map[string]interface{})["cases"].([]interface{})
+	if len(cases) != 4 || !reflect.DeepEqual(cases[0], map[string]interface{}{"name": "com.example.MainTest#testFail", "status": "failed", "time_seconds": 2.5}) {
+		t.Errorf("cases = %v", cases)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import "strings"
+
+const testSizeAll = "all"
+
+// testFilter is the test selection of the filtering inputs
+type testFilter struct {
+	IncludeAnnotations []string
+	ExcludeAnnotations []string
+	IncludePackages    []string
+	// Size is small, medium, large or all
+	Size string
+}
+
+// returns the non empty, trimmed values of a | separated input
+func filterValues(values []string) []string {
+	var filtered []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			filtered = append(filtered, value)
+		}
+	}
+	return filtered
+}
+
+func newTestFilter(includeAnnotations, excludeAnnotations, includePackages []string, size string) testFilter {
+	if size == "" {
+		size = testSizeAll
+	}
+	return testFilter{
+		IncludeAnnotations: filterValues(includeAnnotations),
+		ExcludeAnnotations: filterValues(excludeAnnotations),
+		IncludePackages:    filterValues(includePackages),
+		Size:               size,
+	}
+}
+
+func (f testFilter) empty() bool {
+	return len(f.IncludeAnnotations) == 0 && len(f.ExcludeAnnotations) == 0 && len(f.IncludePackages) == 0 && f.Size == testSizeAll
+}
+
+// returns the filter in the android test-targets syntax of flank (the AndroidJUnitRunner arguments)
+func (f testFilter) androidTestTargets() []string {
+	var targets []string
+	if len(f.IncludeAnnotations) > 0 {
+		targets = append(targets, "annotation "+strings.Join(f.IncludeAnnotations, ","))
+	}
+	if len(f.ExcludeAnnotations) > 0 {
+		targets = append(targets, "notAnnotation "+strings.Join(f.ExcludeAnnotations, ","))
+	}
+	if len(f.IncludePackages) > 0 {
+		targets = append(targets, "package "+strings.Join(f.IncludePackages, ","))
+	}
+	if f.Size != testSizeAll {
+		targets = append(targets, "size "+f.Size)
+	}
+	return targets
+}
+
+// returns the filter as iOS test-targets, flank matches them as regular expressions against the ClassName/methodName
+// of the tests: the included packages are classes or methods (LoginTests or LoginTests/testLogin), a class selects all
+// of its methods; the names of the inputs without an iOS counterpart are returned as unsupported
+func (f testFilter) iosTestTargets() (targets []string, unsupported []string) {
+	if len(f.IncludeAnnotations) > 0 {
+		unsupported = append(unsupported, "include_annotations")
+	}
+	if len(f.ExcludeAnnotations) > 0 {
+		unsupported = append(unsupported, "exclude_annotations")
+	}
+	if f.Size != testSizeAll {
+		unsupported = append(unsupported, "test_size")
+	}
+	for _, pkg := range f.IncludePackages {
+		if !strings.Contains(pkg, "/") {
+			pkg += "/.*"
+		}
+		targets = append(targets, pkg)
+	}
+	return targets, unsupported
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_testFilter(t *testing.T) {
+	tests := []struct {
+		name            string
+		filter          testFilter
+		wantEmpty       bool
+		wantAndroid     []string
+		wantIOS         []string
+		wantUnsupported []string
+	}{
+		{
+			name:      "no filter",
+			filter:    newTestFilter([]string{""}, nil, []string{" "}, ""),
+			wantEmpty: true,
+		},
+		{
+			name: "every filter",
+			filter: newTestFilter(
+				[]string{"com.example.Smoke", " com.example.Critical"},
+				[]string{"org.junit.Ignore"},
+				[]string{"com.example.login", "com.example.payment"},
+				"small",
+			),
+			wantAndroid: []string{
+				"annotation com.example.Smoke,com.example.Critical",
+				"notAnnotation org.junit.Ignore",
+				"package com.example.login,com.example.payment",
+				"size small",
+			},
+			wantIOS:         []string{"com.example.login/.*", "com.example.payment/.*"},
+			wantUnsupported: []string{"include_annotations", "exclude_annotations", "test_size"},
+		},
+		{
+			name:        "ios targets",
+			filter:      newTestFilter(nil, nil, []string{"LoginTests/testLogin", "LaunchTests"}, "all"),
+			wantAndroid: []string{"package LoginTests/testLogin,LaunchTests"},
+			wantIOS:     []string{"LoginTests/testLogin", "LaunchTests/.*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.empty(); got != tt.wantEmpty {
+				t.Errorf("empty() = %v, want %v", got, tt.wantEmpty)
+			}
+			if got := tt.filter.androidTestTargets(); !reflect.DeepEqual(got, tt.wantAndroid) {
+				t.Errorf("androidTestTargets() = %v, want %v", got, tt.wantAndroid)
+			}
+			gotIOS, gotUnsupported := tt.filter.iosTestTargets()
+			if !reflect.DeepEqual(gotIOS, tt.wantIOS) {
+				t.Errorf("iosTestTargets() targets = %v, want %v", gotIOS, tt.wantIOS)
+			}
+			if !reflect.DeepEqual(gotUnsupported, tt.wantUnsupported) {
+				t.Errorf("iosTestTargets() unsupported = %v, want %v", gotUnsupported, tt.wantUnsupported)
+			}
+		})
+	}
+}
+
+func Test_appendTestTargets(t *testing.T) {
+	got := appendTestTargets("size large", []string{"size large", "package com.example", "package com.example"})
+	want := []interface{}{"size large", "package com.example"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("appendTestTargets() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	testHistoryFileSuffix = "_history.json"
+	unknownBranch         = "unknown"
+	topFlakyTestCount     = 10
+
+	testStatusPassed = "passed"
+	testStatusFailed = "failed"
+	testStatusFlaky  = "flaky"
+)
+
+// testHistory is the result history of the tests of a test run name, per branch and test (class#method)
+type testHistory struct {
+	Branches map[string]map[string]*testHistoryEntry `json:"branches"`
+}
+
+type testHistoryEntry struct {
+	Runs         int     `json:"runs"`
+	Passed       int     `json:"passed"`
+	Failed       int     `json:"failed"`
+	Flaky        int     `json:"flaky"`
+	TotalSeconds float64 `json:"total_seconds"`
+	LastStatus   string  `json:"last_status"`
+}
+
+// flakyTest is a test of the history with its flaky rate
+type flakyTest struct {
+	Name  string `json:"name"`
+	Flaky int    `json:"flaky"`
+	Runs  int    `json:"runs"`
+}
+
+func (t flakyTest) String() string {
+	return fmt.Sprintf("%s (flaky in %d of %d runs)", t.Name, t.Flaky, t.Runs)
+}
+
+// historyReport compares a run with the history of its branch
+type historyReport struct {
+	Branch       string      `json:"branch"`
+	NewlyFlaky   []string    `json:"newly_flaky"`
+	NewlyFailing []string    `json:"newly_failing"`
+	TopFlaky     []flakyTest `json:"top_flaky"`
+}
+
+// testRunResult is the result of a test in a run, merged across the devices
+type testRunResult struct {
+	Status  string
+	Seconds float64
+}
+
+// returns the cache file of the test run name, the same name has the same history across builds
+func testHistoryPath(dir, testName string) string {
+	return filepath.Join(dir, unsafeFileNameChars.ReplaceAllString(testName, "_")+testHistoryFileSuffix)
+}
+
+// returns the results of the test cases by class#method: a test failed if it failed on any device,
+// flaky if it was flaky on any device and passed otherwise; skipped tests are left out
+func collectTestRunResults(suites junitTestSuites) map[string]testRunResult {
+	results := map[string]testRunResult{}
+	for _, suite := range suites.Suites {
+		for _, tc := range suite.TestCases {
+			if tc.skipped() {
+				continue
+			}
+
+			status := testStatusPassed
+			switch {
+			case tc.failed() || tc.errored():
+				status = testStatusFailed
+			case tc.Flaky:
+				status = testStatusFlaky
+			}
+
+			name := tc.ClassName + "#" + tc.Name
+			result, ok := results[name]
+			if !ok || status == testStatusFailed || (status == testStatusFlaky && result.Status == testStatusPassed) {
+				result.Status = status
+			}
+			result.Seconds += parseJUnitTime(tc.Time)
+			results[name] = result
+		}
+	}
+	return results
+}
+
+// returns nil if the history file does not exist
+func readTestHistory(pth string) (*testHistory, error) {
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, nil
+	}
+
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	var history testHistory
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse %s, error: %s", pth, err)
+	}
+	return &history, nil
+}
+
+func writeTestHistory(pth string, history *testHistory) error {
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, data)
+}
+
+// compares the results with the history of the branch then adds them to it,
+// nothing is reported as new on the first run of a branch
+func (h *testHistory) update(branch string, results map[string]testRunResult) historyReport {
+	if branch == "" {
+		branch = unknownBranch
+	}
+	if h.Branches == nil {
+		h.Branches = map[string]map[string]*testHistoryEntry{}
+	}
+	tests := h.Branches[branch]
+	if tests == nil {
+		tests = map[string]*testHistoryEntry{}
+		h.Branches[branch] = tests
+	}
+	known := len(tests) > 0
+
+	report := historyReport{Branch: branch, NewlyFlaky: []string{}, NewlyFailing: []string{}}
+	for name, result := range results {
+		entry := tests[name]
+		if entry == nil {
+			entry = &testHistoryEntry{}
+			tests[name] = entry
+		}
+
+		switch result.Status {
+		case testStatusFailed:
+			if known && entry.LastStatus != testStatusFailed {
+				report.NewlyFailing = append(report.NewlyFailing, name)
+			}
+			entry.Failed++
+		case testStatusFlaky:
+			if known && entry.Flaky == 0 {
+				report.NewlyFlaky = append(report.NewlyFlaky, name)
+			}
+			entry.Flaky++
+		default:
+			entry.Passed++
+		}
+		entry.Runs++
+		entry.TotalSeconds += result.Seconds
+		entry.LastStatus = result.Status
+	}
+	sort.Strings(report.NewlyFlaky)
+	sort.Strings(report.NewlyFailing)
+	report.TopFlaky = h.topFlaky(branch, topFlakyTestCount)
+	return report
+}
+
+// returns the tests of the branch with the highest flaky rate, ties are ordered by the flaky count then by name
+func (h *testHistory) topFlaky(branch string, count int) []flakyTest {
+	top := []flakyTest{}
+	for name, entry := range h.Branches[branch] {
+		if entry.Flaky > 0 {
+			top = append(top, flakyTest{Name: name, Flaky: entry.Flaky, Runs: entry.Runs})
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		ri, rj := float64(top[i].Flaky)/float64(top[i].Runs), float64(top[j].Flaky)/float64(top[j].Runs)
+		if ri != rj {
+			return ri > rj
+		}
+		if top[i].Flaky != top[j].Flaky {
+			return top[i].Flaky > top[j].Flaky
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > count {
+		top = top[:count]
+	}
+	return top
+}
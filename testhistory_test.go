@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_collectTestRunResults(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites, err := parseJUnitReport(writeTestFile(t, tmpDir, junitReportFileName, testJUnitReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]testRunResult{
+		"com.example.MainTest#testPass":   {Status: testStatusPassed, Seconds: 2.5},
+		"com.example.MainTest#testFail":   {Status: testStatusFailed, Seconds: 2.5},
+		"com.example.MainTest#testFlaky":  {Status: testStatusFlaky, Seconds: 3},
+		"com.example.OtherTest#testCrash": {Status: testStatusFailed},
+	}
+	if got := collectTestRunResults(suites); !reflect.DeepEqual(got, want) {
+		t.Errorf("collectTestRunResults() = %v, want %v", got, want)
+	}
+}
+
+func Test_testHistory_update(t *testing.T) {
+	history := &testHistory{}
+
+	first := history.update("main", map[string]testRunResult{
+		"A#a": {Status: testStatusPassed, Seconds: 1},
+		"A#b": {Status: testStatusFlaky, Seconds: 2},
+		"A#c": {Status: testStatusFailed, Seconds: 3},
+	})
+	if len(first.NewlyFailing) != 0 || len(first.NewlyFlaky) != 0 {
+		t.Errorf("first run of the branch reported new results: %+v", first)
+	}
+
+	second := history.update("main", map[string]testRunResult{
+		"A#a": {Status: testStatusFlaky, Seconds: 1},
+		"A#b": {Status: testStatusFlaky, Seconds: 2},
+		"A#c": {Status: testStatusFailed, Seconds: 3},
+		"A#d": {Status: testStatusFailed, Seconds: 4},
+	})
+	want := historyReport{
+		Branch:       "main",
+		NewlyFlaky:   []string{"A#a"},
+		NewlyFailing: []string{"A#d"},
+		TopFlaky:     []flakyTest{{Name: "A#b", Flaky: 2, Runs: 2}, {Name: "A#a", Flaky: 1, Runs: 2}},
+	}
+	if !reflect.DeepEqual(second, want) {
+		t.Errorf("update() = %+v, want %+v", second, want)
+	}
+
+	other := history.update("", map[string]testRunResult{"A#a": {Status: testStatusPassed}})
+	if other.Branch != unknownBranch || len(other.TopFlaky) != 0 {
+		t.Errorf("update() of another branch = %+v, want an independent history", other)
+	}
+
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pth := filepath.Join(tmpDir, "history.json")
+	if err := writeTestHistory(pth, history); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readTestHistory(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, history) {
+		t.Errorf("readTestHistory() = %+v, want %+v", read, history)
+	}
+	if missing, err := readTestHistory(filepath.Join(tmpDir, "missing.json")); err != nil || missing != nil {
+		t.Errorf("readTestHistory() of a missing file = %v, %v, want nil, nil", missing, err)
+	}
+	if got := history.Branches["main"]["A#c"]; got.Runs != 2 || got.Failed != 2 || got.TotalSeconds != 6 || got.LastStatus != testStatusFailed {
+		t.Errorf("history of A#c = %+v", got)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	testSelectionImpacted  = "impacted"
+	testSelectionFullSuite = "full_suite"
+)
+
+// android source paths by the gradle conventions: {module}/src/{source set}/{java|kotlin}/{package path}/{Name}.{java|kt}
+var androidSourcePathPattern = regexp.MustCompile(`(?:^|/)src/([^/]+)/(?:java|kotlin)/(.+)/([^/]+)\.(?:java|kt)$`)
+
+// testImpactMapping is the mapping file of the test impact selection
+type testImpactMapping struct {
+	// Mappings map the changed paths to test-targets, a mapping without tests marks changes without test impact (eg.: docs)
+	Mappings []struct {
+		Paths []string `yaml:"paths"`
+		Tests []string `yaml:"tests"`
+	} `yaml:"mappings"`
+	// FullSuite lists the paths whose change runs every test (eg.: build scripts)
+	FullSuite []string `yaml:"full_suite"`
+}
+
+// testImpact is the result of the test impact selection, Targets is empty if the full suite has to run
+type testImpact struct {
+	ChangedFiles []string
+	Targets      []string
+	// Reason explains why the full suite runs
+	Reason string
+}
+
+func readTestImpactMapping(pth string) (testImpactMapping, error) {
+	data, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return testImpactMapping{}, err
+	}
+	var mapping testImpactMapping
+	if err := yaml.Unmarshal(data, &mapping); err != nil {
+		return testImpactMapping{}, err
+	}
+	return mapping, nil
+}
+
+// returns the test-targets of a changed android source file by the naming conventions:
+// a changed instrumentation test runs itself, a changed main source runs the tests of its package,
+// unit test sources have no instrumentation test impact; ok is false if the path is not an android source
+func conventionTestTargets(pth string) (targets []string, ok bool) {
+	match := androidSourcePathPattern.FindStringSubmatch(pth)
+	if match == nil {
+		return nil, false
+	}
+	sourceSet, pkg, name := match[1], strings.Replace(match[2], "/", ".", -1), match[3]
+
+	switch {
+	case strings.HasPrefix(sourceSet, "androidTest"):
+		return []string{"class " + pkg + "." + name}, true
+	case strings.HasPrefix(sourceSet, "test"):
+		return nil, true
+	default:
+		return []string{"package " + pkg}, true
+	}
+}
+
+// selects the test-targets affected by the changed files, if a change can not be mapped the full suite has to run
+func selectImpactedTests(mapping testImpactMapping, changedFiles []string, conventions bool) (testImpact, error) {
+	impact := testImpact{ChangedFiles: changedFiles}
+	if len(changedFiles) == 0 {
+		impact.Reason = "no changed files found"
+		return impact, nil
+	}
+
+	selected := map[string]bool{}
+	for _, pth := range changedFiles {
+		fullSuite, err := matchAnyGlob(mapping.FullSuite, pth)
+		if err != nil {
+			return testImpact{}, err
+		}
+		if fullSuite {
+			impact.Reason = fmt.Sprintf("%s requires the full suite", pth)
+			return impact, nil
+		}
+
+		mapped := false
+		for _, m := range mapping.Mappings {
+			match, err := matchAnyGlob(m.Paths, pth)
+			if err != nil {
+				return testImpact{}, err
+			}
+			if !match {
+				continue
+			}
+			mapped = true
+			for _, target := range m.Tests {
+				selected[target] = true
+			}
+		}
+		if mapped {
+			continue
+		}
+
+		if conventions {
+			if targets, ok := conventionTestTargets(pth); ok {
+				for _, target := range targets {
+					selected[target] = true
+				}
+				continue
+			}
+		}
+		impact.Reason = fmt.Sprintf("the test impact of %s is unknown", pth)
+		return impact, nil
+	}
+
+	if len(selected) == 0 {
+		impact.Reason = "no test is affected by the changes"
+		return impact, nil
+	}
+	for target := range selected {
+		impact.Targets = append(impact.Targets, target)
+	}
+	sort.Strings(impact.Targets)
+	return impact, nil
+}
+
+// returns the values of a class or package test-target, eg.: class com.example.A,com.example.B#test
+func testTargetValues(target, kind string) ([]string, bool) {
+	if !strings.HasPrefix(target, kind+" ") {
+		return nil, false
+	}
+	var values []string
+	for _, value := range strings.Split(strings.TrimPrefix(target, kind+" "), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values, true
+}
+
+// returns the impacted android test-targets as a single filter, as AndroidJUnitRunner does not run the union of
+// a class and a package filter: the classes are collapsed into their packages if a package is affected, otherwise
+// the classes are joined into one class filter. ok is false if a target is neither a class nor a package filter.
+func androidImpactTargets(targets []string) (combined []string, ok bool) {
+	var classes, packages []string
+	for _, target := range targets {
+		if values, isClass := testTargetValues(target, "class"); isClass {
+			classes = append(classes, values...)
+		} else if values, isPackage := testTargetValues(target, "package"); isPackage {
+			packages = append(packages, values...)
+		} else {
+			return nil, false
+		}
+	}
+
+	if len(packages) == 0 {
+		if len(classes) == 0 {
+			return nil, true
+		}
+		return []string{"class " + strings.Join(uniqueSorted(classes), ",")}, true
+	}
+	for _, class := range classes {
+		class = strings.SplitN(class, "#", 2)[0]
+		i := strings.LastIndex(class, ".")
+		if i < 0 {
+			return nil, false
+		}
+		packages = append(packages, class[:i])
+	}
+	return []string{"package " + strings.Join(uniqueSorted(packages), ",")}, true
+}
+
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// returns true if the test-targets of the config select tests, on android the notClass, notPackage
+// and notAnnotation filters only exclude tests
+func hasPositiveTestTargets(value interface{}, android bool) bool {
+	var targets []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		targets = v
+	case string:
+		targets = []interface{}{v}
+	}
+
+	for _, target := range targets {
+		s, ok := target.(string)
+		if !ok || strings.TrimSpace(s) == "" {
+			continue
+		}
+		if !android || !strings.HasPrefix(strings.TrimSpace(s), "not") {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the test-targets to add to the configured ones (value) for the impacted tests, or the reason why the
+// full suite runs: the runner would not select the union of the configured and the impacted tests
+func impactTestTargets(impact testImpact, value interface{}, android bool) ([]string, string) {
+	if len(impact.Targets) == 0 {
+		return nil, impact.Reason
+	}
+	targets := impact.Targets
+	if android {
+		var ok bool
+		if targets, ok = androidImpactTargets(impact.Targets); !ok {
+			return nil, "the impacted test-targets can not be combined into a class or package filter"
+		}
+	}
+	if hasPositiveTestTargets(value, android) {
+		return nil, "the config already selects tests by test-targets"
+	}
+	return targets, ""
+}
+
+// returns the files changed between the merge base of the base ref and HEAD
+func gitChangedFiles(baseRef string) ([]string, error) {
+	cmd := command.New("git", "diff", "--name-only", baseRef+"...HEAD")
+	out, err := cmd.RunAndReturnTrimmedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run git command (%s), error: %s", cmd.PrintableCommandArgs(), err)
+	}
+
+	var files []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
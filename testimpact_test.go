@@ -0,0 +1,193 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const testImpactMappingYML = `mappings:
+- paths:
+  - "feature/payment/**"
+  tests:
+  - package com.example.payment
+  - class com.example.CheckoutTest
+- paths:
+  - "**/*.md"
+  - "docs/**"
+full_suite:
+- "**/build.gradle"
+- "gradle/**"
+`
+
+func Test_selectImpactedTests(t *testing.T) {
+	var mapping testImpactMapping
+	if err := yaml.Unmarshal([]byte(testImpactMappingYML), &mapping); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		changedFiles []string
+		conventions  bool
+		wantTargets  []string
+		wantReason   string
+	}{
+		{
+			name: "mapped and conventional changes",
+			changedFiles: []string{
+				"feature/payment/src/main/java/com/example/payment/Card.kt",
+				"app/src/main/java/com/example/login/LoginViewModel.kt",
+				"app/src/androidTest/java/com/example/login/LoginTest.kt",
+				"app/src/test/java/com/example/login/LoginViewModelTest.kt",
+				"README.md",
+			},
+			conventions: true,
+			wantTargets: []string{"class com.example.CheckoutTest", "class com.example.login.LoginTest", "package com.example.login", "package com.example.payment"},
+		},
+		{
+			name:         "unknown change",
+			changedFiles: []string{"feature/payment/Card.kt", "scripts/release.sh"},
+			conventions:  true,
+			wantReason:   "the test impact of scripts/release.sh is unknown",
+		},
+		{
+			name:         "conventions disabled",
+			changedFiles: []string{"app/src/main/java/com/example/login/LoginViewModel.kt"},
+			wantReason:   "the test impact of app/src/main/java/com/example/login/LoginViewModel.kt is unknown",
+		},
+		{
+			name:         "full suite change",
+			changedFiles: []string{"feature/payment/Card.kt", "app/build.gradle"},
+			conventions:  true,
+			wantReason:   "app/build.gradle requires the full suite",
+		},
+		{
+			name:         "docs only",
+			changedFiles: []string{"docs/setup.md"},
+			wantReason:   "no test is affected by the changes",
+		},
+		{
+			name:       "no changes",
+			wantReason: "no changed files found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectImpactedTests(mapping, tt.changedFiles, tt.conventions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Targets, tt.wantTargets) {
+				t.Errorf("selectImpactedTests() targets = %v, want %v", got.Targets, tt.wantTargets)
+			}
+			if got.Reason != tt.wantReason {
+				t.Errorf("selectImpactedTests() reason = %s, want %s", got.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_androidImpactTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []string
+		want    []string
+		wantOk  bool
+	}{
+		{
+			name:    "classes only",
+			targets: []string{"class com.example.login.LoginTest", "class com.example.CheckoutTest,com.example.login.LoginTest#testLogin"},
+			want:    []string{"class com.example.CheckoutTest,com.example.login.LoginTest,com.example.login.LoginTest#testLogin"},
+			wantOk:  true,
+		},
+		{
+			name:    "classes and packages",
+			targets: []string{"class com.example.CheckoutTest", "class com.example.login.LoginTest#testLogin", "package com.example.payment"},
+			want:    []string{"package com.example,com.example.login,com.example.payment"},
+			wantOk:  true,
+		},
+		{
+			name:    "other filter",
+			targets: []string{"class com.example.CheckoutTest", "annotation com.example.Smoke"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := androidImpactTargets(tt.targets)
+			if !reflect.DeepEqual(got, tt.want) || ok != tt.wantOk {
+				t.Errorf("androidImpactTargets() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_hasPositiveTestTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		android bool
+		want    bool
+	}{
+		{name: "no test-targets", value: nil, android: true, want: false},
+		{name: "exclusions only", value: []interface{}{"notClass com.example.FlakyTest", "notAnnotation org.junit.Ignore"}, android: true, want: false},
+		{name: "package and exclusion", value: []interface{}{"notClass com.example.FlakyTest", "package com.example.login"}, android: true, want: true},
+		{name: "single string", value: "class com.example.LoginTest", android: true, want: true},
+		{name: "ios", value: []interface{}{"LoginTests/.*"}, android: false, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasPositiveTestTargets(tt.value, tt.android); got != tt.want {
+				t.Errorf("hasPositiveTestTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_impactTestTargets(t *testing.T) {
+	mixed := testImpact{Targets: []string{"class com.example.login.LoginTest", "package com.example.payment"}}
+
+	tests := []struct {
+		name       string
+		impact     testImpact
+		value      interface{}
+		android    bool
+		want       []string
+		wantReason string
+	}{
+		{
+			name:    "mixed classes and packages",
+			impact:  mixed,
+			value:   []interface{}{"notAnnotation org.junit.Ignore"},
+			android: true,
+			want:    []string{"package com.example.login,com.example.payment"},
+		},
+		{
+			name:       "mixed classes and packages with configured test-targets",
+			impact:     mixed,
+			value:      []interface{}{"notAnnotation org.junit.Ignore", "annotation com.example.Smoke"},
+			android:    true,
+			wantReason: "the config already selects tests by test-targets",
+		},
+		{
+			name:       "full suite",
+			impact:     testImpact{Reason: "no test is affected by the changes"},
+			android:    true,
+			wantReason: "no test is affected by the changes",
+		},
+		{
+			name:   "ios",
+			impact: testImpact{Targets: []string{"LoginTests/.*"}},
+			want:   []string{"LoginTests/.*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := impactTestTargets(tt.impact, tt.value, tt.android)
+			if !reflect.DeepEqual(got, tt.want) || reason != tt.wantReason {
+				t.Errorf("impactTestTargets() = %v, %q, want %v, %q", got, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-steplib/bitrise-step-flank/dex"
+)
+
+const (
+	modeListTests    = "list_tests"
+	testListFileName = "flank-tests.json"
+	gcsPathPrefix    = "gs://"
+)
+
+// testList is the tests of a test APK, written to flank-tests.json
+type testList struct {
+	TestAPK string          `json:"test_apk"`
+	Total   int             `json:"total"`
+	Classes []dex.TestClass `json:"classes"`
+}
+
+// returns the test APKs of the config: gcloud.test and the tests of additional-app-test-apks,
+// the environment variables of the paths are expanded as flank does, GCS paths are returned separately
+func testAPKPaths(cfg flankConfig) (local []string, remote []string) {
+	paths := []string{cfg.Gcloud.Test}
+	for _, apks := range cfg.Flank.AdditionalAppTestApks {
+		paths = append(paths, apks.Test)
+	}
+
+	for _, pth := range paths {
+		if pth == "" {
+			continue
+		}
+		if strings.HasPrefix(pth, gcsPathPrefix) {
+			remote = append(remote, pth)
+			continue
+		}
+		local = append(local, os.ExpandEnv(pth))
+	}
+	return local, remote
+}
+
+func listAPKTests(pth string) (testList, error) {
+	classes, err := dex.ReadAPK(pth)
+	if err != nil {
+		return testList{}, err
+	}
+
+	list := testList{TestAPK: pth, Classes: dex.ListTests(classes)}
+	if list.Classes == nil {
+		list.Classes = []dex.TestClass{}
+	}
+	for _, class := range list.Classes {
+		list.Total += len(class.Methods)
+	}
+	return list, nil
+}
+
+func writeTestLists(pth string, lists []testList) error {
+	if lists == nil {
+		lists = []testList{}
+	}
+	data, err := json.MarshalIndent(lists, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, data)
+}
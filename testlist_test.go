@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func Test_testAPKPaths(t *testing.T) {
+	if err := os.Setenv("TEST_APK_DIR", "/tmp/apks"); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg flankConfig
+	if err := yaml.Unmarshal([]byte(`gcloud:
+  app: ./app.apk
+  test: $TEST_APK_DIR/test.apk
+flank:
+  additional-app-test-apks:
+  - app: ./other.apk
+    test: ./other-test.apk
+  - test: gs://bucket/lib-test.apk
+`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	local, remote := testAPKPaths(cfg)
+	if want := []string{"/tmp/apks/test.apk", "./other-test.apk"}; !reflect.DeepEqual(local, want) {
+		t.Errorf("testAPKPaths() local = %v, want %v", local, want)
+	}
+	if want := []string{"gs://bucket/lib-test.apk"}; !reflect.DeepEqual(remote, want) {
+		t.Errorf("testAPKPaths() remote = %v, want %v", remote, want)
+	}
+}
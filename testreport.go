@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+const testInfoFileName = "test-info.json"
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9._ -]+`)
+
+// deviceResult is a JUnit xml downloaded by flank for a single device, flank stores them under
+// <result dir>/<matrix or shard dir>/<device dir>/test_result_<n>.xml
+type deviceResult struct {
+	Shard  string
+	Device string
+	Path   string
+}
+
+// walks the result dir and collects the per device JUnit xmls, the merged JUnitReport.xml is not included
+func findDeviceResults(resultDir string) ([]deviceResult, error) {
+	var results []deviceResult
+	if err := filepath.Walk(resultDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasPrefix(info.Name(), "test_result") || filepath.Ext(pth) != ".xml" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(resultDir, filepath.Dir(pth))
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		device := filepath.Base(rel)
+		shard := filepath.Dir(rel)
+		if shard == "." {
+			shard = ""
+		}
+		results = append(results, deviceResult{Shard: filepath.ToSlash(shard), Device: device, Path: pth})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return results, nil
+}
+
+// returns the test name used when the test_name input is not set, eg.: android - flank
+func defaultTestName(platform, configPath string) string {
+	configName := strings.TrimSuffix(filepath.Base(configPath), filepath.Ext(configPath))
+	return platform + " - " + configName
+}
+
+// exports the canonical JUnit report as JUnitReport.xml into <test result dir>/<test name>/ next to a test-info.json,
+// which is the layout the Bitrise Test Reports add-on expects. If perDevice is set, the per device results of the result dir
+// are exported too, each device as a separate test run into <test name> - <shard>/<device>/: the reruns of a device
+// (-rerun_N dirs) are merged into it like in the canonical report, so that the add-on counts a test once per device.
+func exportTestResults(junitPath, resultDir, testResultDir, testName string, perDevice bool, copiedHandler func(src, dest string)) error {
+	destDir, err := createTestRunDir(testResultDir, testName)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(destDir, junitReportFileName)
+	if err := copyFile(junitPath, dest); err != nil {
+		return err
+	}
+	if copiedHandler != nil {
+		copiedHandler(junitPath, dest)
+	}
+	if !perDevice {
+		return nil
+	}
+
+	results, err := findDeviceResults(resultDir)
+	if err != nil {
+		return err
+	}
+	// the attempts of a test are merged in the order of the runs
+	sort.SliceStable(results, func(i, j int) bool {
+		return deviceRerunIndex(results[i].Device) < deviceRerunIndex(results[j].Device)
+	})
+
+	var names []string
+	byDevice := map[string][]deviceResult{}
+	for _, result := range results {
+		name := testName + " - " + path.Join(result.Shard, deviceRerunSuffixPattern.ReplaceAllString(result.Device, ""))
+		if _, ok := byDevice[name]; !ok {
+			names = append(names, name)
+		}
+		byDevice[name] = append(byDevice[name], result)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		suites, err := canonicalSuitesOfDeviceResults(byDevice[name])
+		if err != nil {
+			return err
+		}
+		destDir, err := createTestRunDir(testResultDir, name)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(destDir, junitReportFileName)
+		if err := writeJUnitReport(dest, suites); err != nil {
+			return err
+		}
+		if copiedHandler != nil {
+			for _, result := range byDevice[name] {
+				copiedHandler(result.Path, dest)
+			}
+		}
+	}
+	return nil
+}
+
+// creates <test result dir>/<test name>/ with its test-info.json
+func createTestRunDir(testResultDir, testName string) (string, error) {
+	destDir := filepath.Join(testResultDir, unsafeFileNameChars.ReplaceAllString(testName, "_"))
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return "", err
+	}
+
+	testInfo, err := json.Marshal(map[string]string{"test-name": testName})
+	if err != nil {
+		return "", err
+	}
+	return destDir, fileutil.WriteBytesToFile(filepath.Join(destDir, testInfoFileName), testInfo)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_findDeviceResults(t *testing.T) {
+	resultDir, err := pathutil.NormalizedOSTempDirPath("test-device-results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := createDummyFiles(resultDir, []string{
+		"JUnitReport.xml",
+		"matrix_ids.json",
+		"shard_0/NexusLowRes-28-en-portrait/test_result_1.xml",
+		"shard_0/NexusLowRes-28-en-portrait/logcat",
+		"shard_1/Pixel2-29-en-portrait/test_result_1.xml",
+		"Pixel2-29-en-portrait/test_result_1.xml",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := findDeviceResults(resultDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []deviceResult{
+		{Shard: "", Device: "Pixel2-29-en-portrait", Path: filepath.Join(resultDir, "Pixel2-29-en-portrait/test_result_1.xml")},
+		{Shard: "shard_0", Device: "NexusLowRes-28-en-portrait", Path: filepath.Join(resultDir, "shard_0/NexusLowRes-28-en-portrait/test_result_1.xml")},
+		{Shard: "shard_1", Device: "Pixel2-29-en-portrait", Path: filepath.Join(resultDir, "shard_1/Pixel2-29-en-portrait/test_result_1.xml")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findDeviceResults() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_exportTestResults(t *testing.T) {
+	resultDir, err := pathutil.NormalizedOSTempDirPath("test-results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := createDummyFiles(resultDir, []string{
+		"JUnitReport.xml",
+		"shard_0/NexusLowRes-28-en-portrait/test_result_1.xml",
+		"shard_0/NexusLowRes-28-en-portrait-rerun_1/test_result_1.xml",
+		"shard_0/Pixel2-29-en-portrait/test_result_1.xml",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(resultDir, "shard_0", "NexusLowRes-28-en-portrait"), "test_result_1.xml", testDeviceResult)
+	writeTestFile(t, filepath.Join(resultDir, "shard_0", "NexusLowRes-28-en-portrait-rerun_1"), "test_result_1.xml", testDeviceRerunResult)
+	writeTestFile(t, filepath.Join(resultDir, "shard_0", "Pixel2-29-en-portrait"), "test_result_1.xml", testDeviceRerunResult)
+	junitPath := writeTestFile(t, resultDir, canonicalJUnitFileName, "canonical")
+
+	tests := []struct {
+		name      string
+		perDevice bool
+		wantFiles []string
+		wantInfo  map[string]string
+	}{
+		{
+			name:      "merged only",
+			wantFiles: []string{"android - flank/JUnitReport.xml", "android - flank/test-info.json"},
+			wantInfo:  map[string]string{"android - flank": "android - flank"},
+		},
+		{
+			name:      "per device",
+			perDevice: true,
+			wantFiles: []string{
+				"android - flank - shard_0_NexusLowRes-28-en-portrait/JUnitReport.xml",
+				"android - flank - shard_0_NexusLowRes-28-en-portrait/test-info.json",
+				"android - flank - shard_0_Pixel2-29-en-portrait/JUnitReport.xml",
+				"android - flank - shard_0_Pixel2-29-en-portrait/test-info.json",
+				"android - flank/JUnitReport.xml",
+				"android - flank/test-info.json",
+			},
+			wantInfo: map[string]string{
+				"android - flank": "android - flank",
+				"android - flank - shard_0_NexusLowRes-28-en-portrait": "android - flank - shard_0/NexusLowRes-28-en-portrait",
+				"android - flank - shard_0_Pixel2-29-en-portrait":      "android - flank - shard_0/Pixel2-29-en-portrait",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testResultDir, err := pathutil.NormalizedOSTempDirPath("test-result-dir")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			testName := defaultTestName("android", "./flank.yml")
+			if err := exportTestResults(junitPath, resultDir, testResultDir, testName, tt.perDevice, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := listFilesRecursive(t, testResultDir); !reflect.DeepEqual(got, tt.wantFiles) {
+				t.Errorf("exported files = %v, want %v", got, tt.wantFiles)
+			}
+			for dir, name := range tt.wantInfo {
+				testInfo, err := ioutil.ReadFile(filepath.Join(testResultDir, dir, testInfoFileName))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := `{"test-name":"` + name + `"}`; string(testInfo) != want {
+					t.Errorf("test-info.json of %s = %s, want %s", dir, testInfo, want)
+				}
+			}
+
+			junit, err := ioutil.ReadFile(filepath.Join(testResultDir, "android - flank", junitReportFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(junit) != "canonical" {
+				t.Errorf("exported JUnitReport.xml = %s, want the canonical report", junit)
+			}
+			if !tt.perDevice {
+				return
+			}
+
+			// the rerun of the device is merged into it: testFlaky failed then passed
+			device, err := parseJUnitReport(filepath.Join(testResultDir, "android - flank - shard_0_NexusLowRes-28-en-portrait", junitReportFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary := summarizeTests(device); summary.Total != 2 || summary.Passed != 2 || summary.Flaky != 1 {
+				t.Errorf("summary of the exported device results = %+v", summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const testTargetsForShardKey = "test-targets-for-shard"
+
+// returns the path of the timing file of the test run in the cache dir,
+// the file is a JUnit xml like the one smart flank keeps in GCS
+func timingCachePath(cacheDir, testName string) string {
+	return filepath.Join(cacheDir, unsafeFileNameChars.ReplaceAllString(testName, "_")+"_timing.xml")
+}
+
+// returns the test entry used in the shards and the test targets for a test case, eg.: class com.Foo#test
+func shardEntryName(className, name string) string {
+	return "class " + className + "#" + name
+}
+
+// returns the average duration of the executed (not skipped, failed or errored) test cases by shard entry
+func collectTestTimings(suites junitTestSuites) map[string]float64 {
+	sums := map[string]float64{}
+	counts := map[string]int{}
+	for _, suite := range suites.Suites {
+		for _, tc := range suite.TestCases {
+			if tc.skipped() || tc.failed() || tc.errored() {
+				continue
+			}
+			name := shardEntryName(tc.ClassName, tc.Name)
+			sums[name] += parseJUnitTime(tc.Time)
+			counts[name]++
+		}
+	}
+
+	timings := map[string]float64{}
+	for name, sum := range sums {
+		timings[name] = sum / float64(counts[name])
+	}
+	return timings
+}
+
+// returns the cached timings updated with the latest ones, tests not executed in the latest run keep their cached timing
+func mergeTestTimings(cached, latest map[string]float64) map[string]float64 {
+	merged := map[string]float64{}
+	for name, timing := range cached {
+		merged[name] = timing
+	}
+	for name, timing := range latest {
+		merged[name] = timing
+	}
+	return merged
+}
+
+// reads the cached timings, returns nil if the cache has no timing file yet
+func readTestTimings(pth string) (map[string]float64, error) {
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, nil
+	}
+
+	suites, err := parseJUnitReport(pth)
+	if err != nil {
+		return nil, err
+	}
+	return collectTestTimings(suites), nil
+}
+
+func writeTestTimings(pth string, timings map[string]float64) error {
+	var names []string
+	for name := range timings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	suite := junitTestSuite{Name: "timing"}
+	for _, name := range names {
+		className, testName := name, ""
+		if idx := strings.LastIndex(name, "#"); idx != -1 {
+			className, testName = name[:idx], name[idx+1:]
+		}
+		suite.TestCases = append(suite.TestCases, junitTestCase{
+			ClassName: strings.TrimPrefix(className, "class "),
+			Name:      testName,
+			Time:      strconv.FormatFloat(timings[name], 'f', 3, 64),
+		})
+	}
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, append([]byte(xml.Header), data...))
+}
+
+// redistributes the tests of the shards into the same number of shards, so that the estimated shard durations are balanced:
+// the longest tests are assigned first, each to the shard with the shortest estimated duration so far
+func balanceShards(shards []shard, times shardTimes) []shard {
+	if len(shards) == 0 {
+		return nil
+	}
+
+	var tests []string
+	for _, s := range shards {
+		tests = append(tests, s.Tests...)
+	}
+	sort.SliceStable(tests, func(i, j int) bool {
+		if ti, tj := times.testSeconds(tests[i]), times.testSeconds(tests[j]); ti != tj {
+			return ti > tj
+		}
+		return tests[i] < tests[j]
+	})
+
+	balanced := make([]shard, len(shards))
+	durations := make([]float64, len(shards))
+	for i := range balanced {
+		balanced[i] = shard{Matrix: shards[i].Matrix, Index: i}
+	}
+	for _, test := range tests {
+		shortest := 0
+		for i := range durations {
+			if durations[i] < durations[shortest] {
+				shortest = i
+			}
+		}
+		balanced[shortest].Tests = append(balanced[shortest].Tests, test)
+		durations[shortest] += times.testSeconds(test)
+	}
+
+	var nonEmpty []shard
+	for _, s := range balanced {
+		if len(s.Tests) == 0 {
+			continue
+		}
+		sort.Strings(s.Tests)
+		s.Index = len(nonEmpty)
+		nonEmpty = append(nonEmpty, s)
+	}
+	return nonEmpty
+}
+
+// returns the test-targets-for-shard value of the shards, the targets of a shard are grouped by their type:
+// class com.Foo#a,com.Foo#b;package com.bar
+func testTargetsForShard(shards []shard) []string {
+	var targets []string
+	for _, s := range shards {
+		var types []string
+		byType := map[string][]string{}
+		for _, test := range s.Tests {
+			split := strings.SplitN(test, " ", 2)
+			if len(split) != 2 {
+				continue
+			}
+			if _, ok := byType[split[0]]; !ok {
+				types = append(types, split[0])
+			}
+			byType[split[0]] = append(byType[split[0]], split[1])
+		}
+
+		var groups []string
+		for _, t := range types {
+			groups = append(groups, t+" "+strings.Join(byType[t], ","))
+		}
+		targets = append(targets, strings.Join(groups, ";"))
+	}
+	return targets
+}
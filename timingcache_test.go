@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func Test_testTimings(t *testing.T) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-timing-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites, err := parseJUnitReport(writeTestFile(t, tmpDir, junitReportFileName, testJUnitReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest := collectTestTimings(suites)
+	for _, name := range []string{"class com.example.MainTest#testFail", "class com.example.MainTest#testSkipped"} {
+		if _, ok := latest[name]; ok {
+			t.Errorf("collectTestTimings() contains the not executed %s", name)
+		}
+	}
+
+	cached := map[string]float64{"class com.example.RemovedTest#testA": 7, "class com.example.MainTest#testFlaky": 100}
+	merged := mergeTestTimings(cached, latest)
+	if merged["class com.example.RemovedTest#testA"] != 7 {
+		t.Errorf("mergeTestTimings() lost the cached timing: %v", merged)
+	}
+	if merged["class com.example.MainTest#testFlaky"] != latest["class com.example.MainTest#testFlaky"] {
+		t.Errorf("mergeTestTimings() kept the outdated timing: %v", merged)
+	}
+
+	pth := timingCachePath(filepath.Join(tmpDir, "cache"), "android - flank/app")
+	if filepath.Base(pth) != "android - flank_app_timing.xml" {
+		t.Errorf("timingCachePath() = %s", pth)
+	}
+	if err := writeTestTimings(pth, merged); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readTestTimings(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, merged) {
+		t.Errorf("readTestTimings() = %v, want %v", got, merged)
+	}
+
+	if got, err := readTestTimings(filepath.Join(tmpDir, "missing.xml")); err != nil || got != nil {
+		t.Errorf("readTestTimings() of a missing file = %v, %v, want nil, nil", got, err)
+	}
+}
+
+func Test_balanceShards(t *testing.T) {
+	shards := []shard{
+		{Matrix: "matrix-0", Index: 0, Tests: []string{"class com.A#a", "class com.A#b", "class com.A#c"}},
+		{Matrix: "matrix-0", Index: 1, Tests: []string{"class com.B#a", "class com.C"}},
+	}
+	times := shardTimes{
+		TestTime:      10,
+		ClassTestTime: 20,
+		Timings:       map[string]float64{"class com.A#a": 60, "class com.A#b": 30, "class com.A#c": 25, "class com.B#a": 5},
+	}
+
+	// 60s -> 0, 30s -> 1, 25s -> 1 (55s), the class (20s) -> 1 (75s), 5s -> 0 (65s)
+	got := balanceShards(shards, times)
+	want := []shard{
+		{Matrix: "matrix-0", Index: 0, Tests: []string{"class com.A#a", "class com.B#a"}},
+		{Matrix: "matrix-0", Index: 1, Tests: []string{"class com.A#b", "class com.A#c", "class com.C"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("balanceShards() = %+v, want %+v", got, want)
+	}
+
+	targets := testTargetsForShard([]shard{{Tests: []string{"class com.A#a", "class com.A#b", "package com.d"}}})
+	if !reflect.DeepEqual(targets, []string{"class com.A#a,com.A#b;package com.d"}) {
+		t.Errorf("testTargetsForShard() = %v", targets)
+	}
+}
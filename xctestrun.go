@@ -0,0 +1,268 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/bitrise-step-flank/plist"
+)
+
+const (
+	xctestrunMetadataKey = "__xctestrun_metadata__"
+	testHostPlaceholder  = "__TESTHOST__"
+	testRootPlaceholder  = "__TESTROOT__"
+	xctestExtension      = ".xctest"
+)
+
+// xctestTarget is a test target of an xctestrun file
+type xctestTarget struct {
+	Name           string
+	Configuration  string
+	UITest         bool
+	TestBundlePath string
+	TestHostPath   string
+}
+
+// xctestrun is the content of an xctestrun file, FormatVersion 1 files have no test plan and configurations
+type xctestrun struct {
+	FormatVersion  int64
+	TestPlan       string
+	Configurations []string
+	Targets        []xctestTarget
+}
+
+func plistString(dict map[string]interface{}, key string) string {
+	s, _ := dict[key].(string)
+	return s
+}
+
+func parseXCTestTarget(name, configuration string, dict map[string]interface{}) xctestTarget {
+	uiTest, _ := dict["IsUITestBundle"].(bool)
+	return xctestTarget{
+		Name:           name,
+		Configuration:  configuration,
+		UITest:         uiTest,
+		TestBundlePath: plistString(dict, "TestBundlePath"),
+		TestHostPath:   plistString(dict, "TestHostPath"),
+	}
+}
+
+func parseXCTestRun(data []byte) (xctestrun, error) {
+	value, err := plist.Decode(data)
+	if err != nil {
+		return xctestrun{}, err
+	}
+	root, ok := value.(map[string]interface{})
+	if !ok {
+		return xctestrun{}, fmt.Errorf("xctestrun root is not a dict")
+	}
+
+	run := xctestrun{FormatVersion: 1}
+	if metadata, ok := root[xctestrunMetadataKey].(map[string]interface{}); ok {
+		if version, ok := metadata["FormatVersion"].(int64); ok {
+			run.FormatVersion = version
+		}
+	}
+
+	if run.FormatVersion == 1 {
+		var names []string
+		for name := range root {
+			if name != xctestrunMetadataKey {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			dict, ok := root[name].(map[string]interface{})
+			if !ok {
+				return xctestrun{}, fmt.Errorf("test target %s is not a dict", name)
+			}
+			run.Targets = append(run.Targets, parseXCTestTarget(name, "", dict))
+		}
+		return run, nil
+	}
+
+	if testPlan, ok := root["TestPlan"].(map[string]interface{}); ok {
+		run.TestPlan = plistString(testPlan, "Name")
+	}
+	configurations, _ := root["TestConfigurations"].([]interface{})
+	for _, c := range configurations {
+		configuration, ok := c.(map[string]interface{})
+		if !ok {
+			return xctestrun{}, fmt.Errorf("test configuration is not a dict")
+		}
+		configurationName := plistString(configuration, "Name")
+		run.Configurations = append(run.Configurations, configurationName)
+
+		targets, _ := configuration["TestTargets"].([]interface{})
+		for _, t := range targets {
+			target, ok := t.(map[string]interface{})
+			if !ok {
+				return xctestrun{}, fmt.Errorf("test target of %s is not a dict", configurationName)
+			}
+			run.Targets = append(run.Targets, parseXCTestTarget(plistString(target, "BlueprintName"), configurationName, target))
+		}
+	}
+	return run, nil
+}
+
+func readXCTestRun(pth string) (xctestrun, error) {
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return xctestrun{}, err
+	}
+	return parseXCTestRun(data)
+}
+
+// returns the names of the test targets, a target of multiple configurations is listed once
+func (r xctestrun) targetNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, target := range r.Targets {
+		if !seen[target.Name] {
+			seen[target.Name] = true
+			names = append(names, target.Name)
+		}
+	}
+	return names
+}
+
+// returns the path of the test bundle executable relative to the test root,
+// for example __TESTHOST__/PlugIns/MyAppTests.xctest of the __TESTROOT__/MyApp.app host is MyApp.app/PlugIns/MyAppTests.xctest/MyAppTests
+func (t xctestTarget) executablePath() string {
+	bundle := strings.Replace(t.TestBundlePath, testHostPlaceholder, t.TestHostPath, 1)
+	bundle = strings.TrimPrefix(strings.Replace(bundle, testRootPlaceholder, "", 1), "/")
+	return path.Join(bundle, strings.TrimSuffix(path.Base(bundle), xctestExtension))
+}
+
+// reads the test bundle executables of the targets from the test zip, keyed by target name,
+// the zip entries are matched by path suffix as the build products may be nested in the zip
+func readTestBundleExecutables(zipPath string, targets []xctestTarget) (map[string][]byte, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Warnf("Failed to close file, error: %s", err)
+		}
+	}()
+
+	executables := map[string][]byte{}
+	for _, target := range targets {
+		if _, ok := executables[target.Name]; ok {
+			continue
+		}
+
+		executablePath := target.executablePath()
+		for _, f := range r.File {
+			if f.Name != executablePath && !strings.HasSuffix(f.Name, "/"+executablePath) {
+				continue
+			}
+
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			data, err := ioutil.ReadAll(rc)
+			if err := rc.Close(); err != nil {
+				log.Warnf("Failed to close file, error: %s", err)
+			}
+			if err != nil {
+				return nil, err
+			}
+			executables[target.Name] = data
+			break
+		}
+	}
+	return executables, nil
+}
+
+// returns the problems of the flank test-targets: flank matches them as regular expressions against the
+// ClassName/methodName of the tests, the literal class and method names are looked up in the test bundle executables,
+// the entries are not checked if an executable is missing
+func validateTestTargets(run xctestrun, executables map[string][]byte, testTargets []string) []string {
+	for _, name := range run.targetNames() {
+		if _, ok := executables[name]; !ok {
+			return nil
+		}
+	}
+
+	var problems []string
+	for _, testTarget := range testTargets {
+		parts := strings.Split(testTarget, "/")
+		if len(parts) > 2 {
+			problems = append(problems, fmt.Sprintf("%s: expected ClassName or ClassName/methodName", testTarget))
+			continue
+		}
+
+		for _, name := range parts {
+			// a pattern can not be looked up
+			if name == "" || regexp.QuoteMeta(name) != name {
+				continue
+			}
+			if !executablesContain(executables, name) {
+				problems = append(problems, fmt.Sprintf("%s: %s not found in the test bundles", testTarget, name))
+				break
+			}
+		}
+	}
+	return problems
+}
+
+func executablesContain(executables map[string][]byte, name string) bool {
+	for _, executable := range executables {
+		if bytes.Contains(executable, []byte(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the problems of the flank only-test-configuration and skip-test-configuration settings
+func validateTestConfigurations(run xctestrun, only, skip string) []string {
+	var problems []string
+	for _, setting := range []struct{ key, name string }{
+		{"only-test-configuration", only},
+		{"skip-test-configuration", skip},
+	} {
+		if setting.name == "" {
+			continue
+		}
+		if !sliceContains(run.Configurations, setting.name) {
+			problems = append(problems, fmt.Sprintf("%s: test configuration %s not found in the xctestrun", setting.key, setting.name))
+		}
+	}
+	return problems
+}
+
+func sliceContains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the test zip and the xctestrun file used by flank, command flags override the config values
+func iosTestPaths(cfg flankConfig, commandFlags []string) (testZip string, xctestrunPath string) {
+	testZip = cfg.Gcloud.Test
+	if value := flagValue(commandFlags, "--test"); value != "" {
+		testZip = value
+	}
+	xctestrunPath = cfg.Gcloud.XCTestRunFile
+	if value := flagValue(commandFlags, "--xctestrun-file"); value != "" {
+		xctestrunPath = value
+	}
+	return os.ExpandEnv(testZip), os.ExpandEnv(xctestrunPath)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testXCTestRunV2 = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>TestConfigurations</key>
+	<array>
+		<dict>
+			<key>Name</key>
+			<string>English</string>
+			<key>TestTargets</key>
+			<array>
+				<dict>
+					<key>BlueprintName</key>
+					<string>MyAppTests</string>
+					<key>TestBundlePath</key>
+					<string>__TESTHOST__/PlugIns/MyAppTests.xctest</string>
+					<key>TestHostPath</key>
+					<string>__TESTROOT__/Debug-iphoneos/MyApp.app</string>
+				</dict>
+				<dict>
+					<key>BlueprintName</key>
+					<string>MyAppUITests</string>
+					<key>IsUITestBundle</key>
+					<true/>
+					<key>TestBundlePath</key>
+					<string>__TESTHOST__/PlugIns/MyAppUITests.xctest</string>
+					<key>TestHostPath</key>
+					<string>__TESTROOT__/Debug-iphoneos/MyAppUITests-Runner.app</string>
+				</dict>
+			</array>
+		</dict>
+		<dict>
+			<key>Name</key>
+			<string>German</string>
+			<key>TestTargets</key>
+			<array>
+				<dict>
+					<key>BlueprintName</key>
+					<string>MyAppTests</string>
+					<key>TestBundlePath</key>
+					<string>__TESTHOST__/PlugIns/MyAppTests.xctest</string>
+					<key>TestHostPath</key>
+					<string>__TESTROOT__/Debug-iphoneos/MyApp.app</string>
+				</dict>
+			</array>
+		</dict>
+	</array>
+	<key>TestPlan</key>
+	<dict>
+		<key>Name</key>
+		<string>MyPlan</string>
+	</dict>
+	<key>__xctestrun_metadata__</key>
+	<dict>
+		<key>FormatVersion</key>
+		<integer>2</integer>
+	</dict>
+</dict>
+</plist>
+`
+
+func Test_parseXCTestRun(t *testing.T) {
+	unitTarget := xctestTarget{
+		Name:           "MyAppTests",
+		TestBundlePath: "__TESTHOST__/PlugIns/MyAppTests.xctest",
+		TestHostPath:   "__TESTROOT__/Debug-iphoneos/MyApp.app",
+	}
+	uiTarget := xctestTarget{
+		Name:           "MyAppUITests",
+		Configuration:  "English",
+		UITest:         true,
+		TestBundlePath: "__TESTHOST__/PlugIns/MyAppUITests.xctest",
+		TestHostPath:   "__TESTROOT__/Debug-iphoneos/MyAppUITests-Runner.app",
+	}
+	englishUnitTarget, germanUnitTarget := unitTarget, unitTarget
+	englishUnitTarget.Configuration = "English"
+	germanUnitTarget.Configuration = "German"
+
+	tests := []struct {
+		name    string
+		data    string
+		want    xctestrun
+		wantErr bool
+	}{
+		{
+			name: "format version 2",
+			data: testXCTestRunV2,
+			want: xctestrun{
+				FormatVersion:  2,
+				TestPlan:       "MyPlan",
+				Configurations: []string{"English", "German"},
+				Targets:        []xctestTarget{englishUnitTarget, uiTarget, germanUnitTarget},
+			},
+		},
+		{
+			name: "format version 1",
+			data: `<plist version="1.0"><dict>
+	<key>MyAppTests</key>
+	<dict>
+		<key>TestBundlePath</key>
+		<string>__TESTHOST__/PlugIns/MyAppTests.xctest</string>
+		<key>TestHostPath</key>
+		<string>__TESTROOT__/Debug-iphoneos/MyApp.app</string>
+	</dict>
+	<key>__xctestrun_metadata__</key>
+	<dict><key>FormatVersion</key><integer>1</integer></dict>
+</dict></plist>`,
+			want: xctestrun{FormatVersion: 1, Targets: []xctestTarget{unitTarget}},
+		},
+		{
+			name:    "not a dict",
+			data:    `<plist version="1.0"><array/></plist>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseXCTestRun([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseXCTestRun() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseXCTestRun() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateTestTargets(t *testing.T) {
+	run, err := parseXCTestRun([]byte(testXCTestRunV2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "test-xctestrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Log(err)
+		}
+	}()
+
+	zipPath := filepath.Join(tmpDir, "MyTests.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	fw, err := w.Create("Build/Products/Debug-iphoneos/MyApp.app/PlugIns/MyAppTests.xctest/MyAppTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("\x00_TtC10MyAppTests10LoginTests\x00testLogin\x00testLogout\x00")); err != nil {
+		t.Fatal(err)
+	}
+	fw, err = w.Create("Build/Products/Debug-iphoneos/MyAppUITests-Runner.app/PlugIns/MyAppUITests.xctest/MyAppUITests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("\x00_TtC12MyAppUITests11LaunchTests\x00testLaunch\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	executables, err := readTestBundleExecutables(zipPath, run.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := executables["MyAppTests"]; !ok {
+		t.Fatalf("readTestBundleExecutables() = %v, missing MyAppTests", executables)
+	}
+	if _, ok := executables["MyAppUITests"]; !ok {
+		t.Fatalf("readTestBundleExecutables() = %v, missing MyAppUITests", executables)
+	}
+
+	got := validateTestTargets(run, executables, []string{
+		"LoginTests/testLogin",
+		"LoginTests/testSignUp",
+		"LaunchTests",
+		"LaunchTests/test.*",
+		"OtherTests",
+		"MyAppTests/LoginTests/testLogin",
+	})
+	want := []string{
+		"LoginTests/testSignUp: testSignUp not found in the test bundles",
+		"OtherTests: OtherTests not found in the test bundles",
+		"MyAppTests/LoginTests/testLogin: expected ClassName or ClassName/methodName",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("validateTestTargets() = %v, want %v", got, want)
+	}
+
+	delete(executables, "MyAppUITests")
+	if got := validateTestTargets(run, executables, []string{"OtherTests"}); got != nil {
+		t.Errorf("validateTestTargets() with a missing test bundle = %v, want nil", got)
+	}
+
+	got = validateTestConfigurations(run, "German", "French")
+	want = []string{"skip-test-configuration: test configuration French not found in the xctestrun"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("validateTestConfigurations() = %v, want %v", got, want)
+	}
+}